@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+)
+
+const (
+	//TransportOCI is OCI image layout directory
+	TransportOCI = "oci"
+	//TransportOCIArchive is tarball of OCI image layout
+	TransportOCIArchive = "oci-archive"
+	//TransportDockerArchive is tarball produced by docker save
+	TransportDockerArchive = "docker-archive"
+)
+
+//Source describes local image location in transport:path[:reference] format
+type Source struct {
+	Transport string
+	Path      string
+	Reference string
+}
+
+//ParseSource parses transport:path[:reference] image location
+func ParseSource(src string) (*Source, error) {
+	s := strings.SplitN(src, ":", 3)
+	if len(s) < 2 || len(s[1]) == 0 {
+		return nil, fmt.Errorf("invalid archive reference %q. Format should be following: [transport:path[:reference]] e.g. docker-archive:/tmp/centos.tar", src)
+	}
+	switch s[0] {
+	case TransportOCI, TransportOCIArchive, TransportDockerArchive:
+	default:
+		return nil, fmt.Errorf("unsupported transport %q. Supported transports: %s, %s, %s", s[0], TransportOCI, TransportOCIArchive, TransportDockerArchive)
+	}
+	source := &Source{Transport: s[0], Path: s[1]}
+	if len(s) > 2 {
+		source.Reference = s[2]
+	}
+	return source, nil
+}
+
+//String returns source in transport:path[:reference] format
+func (s *Source) String() string {
+	if s.Reference == "" {
+		return s.Transport + ":" + s.Path
+	}
+	return s.Transport + ":" + s.Path + ":" + s.Reference
+}
+
+func (s *Source) open() (fileStore, error) {
+	if s.Transport == TransportOCI {
+		return openDirStore(s.Path)
+	}
+	return openTarStore(s.Path)
+}
+
+func (s *Source) read(store fileStore) (*image, error) {
+	if s.Transport == TransportDockerArchive {
+		return readDockerArchive(store, s.Reference)
+	}
+	return readOCI(store, s.Reference)
+}
+
+//Import holds archive import structure used to push locally stored image into a Registry
+type Import struct {
+	Source       *Source
+	DestRegistry string
+	DestImage    string
+	DestImageTag string
+	DestUsername string
+	DestPassword string
+	DestInsecure bool
+}
+
+//ImportImage pushes image from OCI layout or docker archive into Destination Registry. Failures are returned to the caller
+func (im *Import) ImportImage() error {
+	fmt.Println("Preparing Image Import")
+	store, err := im.Source.open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", im.Source.String(), err)
+	}
+	defer store.Close()
+	//Registry is connected before layers are compressed, so failed connection leaves no temporary files behind
+	destHub, err := connectDestination(im.DestRegistry, im.DestUsername, im.DestPassword, im.DestInsecure)
+	if err != nil {
+		return err
+	}
+	defer destHub.Close()
+	img, err := im.Source.read(store)
+	if err != nil {
+		return fmt.Errorf("failed to read image from %s: %v", im.Source.String(), err)
+	}
+	defer img.cleanup()
+	fmt.Println("Source image: " + im.Source.String())
+	fmt.Println("Destination image: " + im.DestImage + ":" + im.DestImageTag)
+
+	if err := ensureBlobs(destHub, im.DestImage, img, nil); err != nil {
+		return fmt.Errorf("error occurred while uploading layers: %v", err)
+	}
+
+	fmt.Println("Submitting Image Manifest")
+	if err := putImageManifests(destHub, im.DestImage, im.DestImageTag, img); err != nil {
+		return fmt.Errorf("manifest update error: %v", err)
+	}
+	if err := destHub.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Import Complete")
+	return nil
+}
+
+//connectedRegistry is Registry backend closed by no-op
+type connectedRegistry struct {
+	*backend.Registry
+}
+
+func (r connectedRegistry) Close() error {
+	return nil
+}
+
+//connectDestination opens OCI image layout of oci: or oci-archive: URL, or connects Registry. Caller closes returned backend
+func connectDestination(url string, username string, password string, insecure bool) (ClosableBackend, error) {
+	if IsLayout(url) {
+		return OpenBackend(url)
+	}
+	hub, err := connection.Connect(url, username, password, insecure)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to registry %s: %v", url, err)
+	}
+	return connectedRegistry{backend.NewRegistry(hub)}, nil
+}
@@ -0,0 +1,270 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/dustin/go-humanize"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/inventory"
+	"github.com/vbaksa/promoter/progress"
+)
+
+const (
+	ociLayoutFile = "oci-layout"
+	bundleFile    = "bundle.json"
+)
+
+//bundleMetadata is stored next to OCI layout files and lists blobs left out of the bundle
+type bundleMetadata struct {
+	Inventory string                     `json:"inventory,omitempty"`
+	Excluded  map[digest.Digest][]string `json:"excluded"`
+}
+
+//Bundle holds delta bundle structure used to pack images for air-gapped transfer
+type Bundle struct {
+	SrcRegistry      string
+	SrcImages        []string
+	SrcUsername      string
+	SrcPassword      string
+	SrcInsecure      bool
+	ExcludeInventory string
+	Output           string
+	//Progress receives status messages and download progress, Console when nil
+	Progress progress.Progress
+}
+
+//bundleImage holds manifests of bundled tag as Source Registry stores them. Child manifests come before the index referencing them
+type bundleImage struct {
+	repository string
+	tag        string
+	img        *image
+}
+
+//CreateBundle writes specified image tags into OCI layout tarball. Blobs recorded in the exclude inventory are left out.
+//Failures are returned to the caller and partially written bundle is removed
+func (b *Bundle) CreateBundle() error {
+	p := progress.Or(b.Progress)
+	p.Printf("Preparing bundle\n")
+	metadata := &bundleMetadata{Excluded: make(map[digest.Digest][]string)}
+	var inv *inventory.Inventory
+	if len(b.ExcludeInventory) > 0 {
+		var err error
+		inv, err = inventory.Load(b.ExcludeInventory)
+		if err != nil {
+			return fmt.Errorf("failed to load inventory: %v", err)
+		}
+		metadata.Inventory = inv.Registry
+		p.Printf("Inventory of %s contains %d blobs\n", inv.Registry, len(inv.Blobs))
+	}
+	srcHub, err := connection.Connect(b.SrcRegistry, b.SrcUsername, b.SrcPassword, b.SrcInsecure)
+	if err != nil {
+		return fmt.Errorf("cannot connect to registry %s: %v", b.SrcRegistry, err)
+	}
+	src := backend.NewRegistry(srcHub)
+
+	images := make([]bundleImage, 0, len(b.SrcImages))
+	blobs := make([]digest.Digest, 0)
+	seen := make(map[digest.Digest]bool)
+	for _, name := range b.SrcImages {
+		repository, tag := splitImageTag(name)
+		img := &image{}
+		if err := addStoredManifest(src, repository, tag, img); err != nil {
+			return fmt.Errorf("failed to download Source Image manifest %s: %v", name, err)
+		}
+		images = append(images, bundleImage{repository: repository, tag: tag, img: img})
+		for _, d := range img.digests() {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			if inv != nil && inv.Has(d) {
+				metadata.Excluded[d] = inv.Blobs[d]
+				continue
+			}
+			blobs = append(blobs, d)
+		}
+	}
+	p.Printf("Bundling %d images, %d blobs. Blobs excluded by inventory: %d\n", len(images), len(blobs), len(metadata.Excluded))
+
+	if err := b.writeBundle(p, src, images, blobs, metadata); err != nil {
+		os.Remove(b.Output)
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	p.Printf("Bundle written to %s\n", b.Output)
+	return nil
+}
+
+//addStoredManifest registers manifest of reference together with its config and layer blobs. Index manifests are followed into every child manifest.
+//Foreign layers are skipped since clients pull them from their own URLs
+func addStoredManifest(src backend.Backend, repository string, reference string, img *image) error {
+	mediaType, payload, err := src.RawManifest(repository, reference)
+	if err != nil {
+		return err
+	}
+	refs, err := backend.ParseReferences(mediaType, payload)
+	if err != nil {
+		return err
+	}
+	for _, m := range refs.Manifests {
+		if err := addStoredManifest(src, repository, m.Digest.String(), img); err != nil {
+			return err
+		}
+	}
+	for _, desc := range refs.Blobs {
+		if len(desc.URLs) == 0 {
+			img.addBlob(desc, nil)
+		}
+	}
+	img.addManifest(mediaType, payload)
+	return nil
+}
+
+func (b *Bundle) writeBundle(p progress.Progress, src backend.Backend, images []bundleImage, blobs []digest.Digest, metadata *bundleMetadata) error {
+	sizes := make(map[digest.Digest]int64)
+	var totalSize int64
+	for _, d := range blobs {
+		size, err := blobSize(src, images, d)
+		if err != nil {
+			return fmt.Errorf("cannot inspect blob %s: %v", d, err)
+		}
+		sizes[d] = size
+		totalSize = totalSize + size
+	}
+	p.Printf("Going to download around %s of layer data\n", humanize.Bytes(uint64(totalSize)))
+
+	f, err := os.Create(b.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	if err := writeTarFile(tw, ociLayoutFile, []byte(ociLayout)); err != nil {
+		return err
+	}
+	index := ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: make([]ociDescriptor, 0, len(images))}
+	written := make(map[digest.Digest]bool)
+	for _, i := range images {
+		for _, m := range i.img.manifests {
+			if written[m.digest] {
+				continue
+			}
+			written[m.digest] = true
+			if err := writeTarFile(tw, blobPath(m.digest), m.payload); err != nil {
+				return err
+			}
+		}
+		top := i.img.manifests[len(i.img.manifests)-1]
+		index.Manifests = append(index.Manifests, ociDescriptor{
+			MediaType:   top.mediaType,
+			Digest:      top.digest,
+			Size:        int64(len(top.payload)),
+			Annotations: map[string]string{annotationRefName: i.repository + ":" + i.tag},
+		})
+	}
+
+	bar := p.Stage(progress.StageLayers, int64(len(blobs)), progress.Count)
+	for _, d := range blobs {
+		if err := writeBundleBlob(tw, src, images, d, sizes[d]); err != nil {
+			bar.Finish()
+			return err
+		}
+		bar.Add(1)
+	}
+	bar.Finish()
+
+	data, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, ociIndexFile, data); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(metadata, "", "   ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, bundleFile, data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+//blobSize looks blob up in every bundled repository since blobs are not always shared across repositories
+func blobSize(src backend.Backend, images []bundleImage, d digest.Digest) (int64, error) {
+	var err error
+	for _, i := range images {
+		var desc distribution.Descriptor
+		desc, err = src.LayerMetadata(i.repository, d)
+		if err == nil {
+			return desc.Size, nil
+		}
+	}
+	return 0, err
+}
+
+//writeBundleBlob streams blob from Source Registry straight into bundle verifying its digest
+func writeBundleBlob(tw *tar.Writer, src backend.Backend, images []bundleImage, d digest.Digest, size int64) error {
+	var reader io.ReadCloser
+	var err error
+	for _, i := range images {
+		reader, err = src.DownloadLayer(i.repository, d)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot download blob %s: %v", d, err)
+	}
+	defer reader.Close()
+	verifier, err := digest.NewDigestVerifier(d)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(tarHeader(blobPath(d), size)); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, io.TeeReader(reader, verifier), size); err != nil {
+		return fmt.Errorf("cannot download blob %s: %v", d, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s content does not match its digest", d)
+	}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(tarHeader(name, int64(len(data)))); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func tarHeader(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+}
+
+//splitImageTag splits repository/image:tag reference. Tag defaults to latest
+func splitImageTag(name string) (string, string) {
+	for i := len(name) - 1; i >= 0 && name[i] != '/'; i-- {
+		if name[i] == ':' {
+			return name[:i], name[i+1:]
+		}
+	}
+	return name, "latest"
+}
@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema2"
+)
+
+const (
+	dockerManifestFile     = "manifest.json"
+	dockerRepositoriesFile = "repositories"
+)
+
+//dockerArchiveEntry is single image record of docker save manifest.json
+type dockerArchiveEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func readDockerArchive(store fileStore, ref string) (*image, error) {
+	data, err := readFile(store, dockerManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("not a docker save archive: %v", err)
+	}
+	var entries []dockerArchiveEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", dockerManifestFile, err)
+	}
+	addLegacyRepoTags(store, entries)
+	entry, err := selectDockerArchiveEntry(entries, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &image{}
+	config, err := readFile(store, entry.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config %s: %v", entry.Config, err)
+	}
+	m := schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    digest.FromBytes(config),
+		},
+		Layers: make([]distribution.Descriptor, 0, len(entry.Layers)),
+	}
+	img.addBlob(m.Config, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(config)), nil
+	})
+	for i, l := range entry.Layers {
+		fmt.Printf("Preparing layer %d of %d\n", i+1, len(entry.Layers))
+		desc, tempFile, err := compressLayer(store, l)
+		if err != nil {
+			img.cleanup()
+			return nil, fmt.Errorf("failed to prepare layer %s: %v", l, err)
+		}
+		img.tempFiles = append(img.tempFiles, tempFile)
+		m.Layers = append(m.Layers, desc)
+		img.addBlob(desc, func() (io.ReadCloser, error) {
+			return os.Open(tempFile)
+		})
+	}
+	deserialized, err := schema2.FromStruct(m)
+	if err != nil {
+		img.cleanup()
+		return nil, err
+	}
+	mediaType, payload, _ := deserialized.Payload()
+	img.addManifest(mediaType, payload)
+	return img, nil
+}
+
+//addLegacyRepoTags fills missing RepoTags from the legacy repositories file which maps repository tags to top layer ids
+func addLegacyRepoTags(store fileStore, entries []dockerArchiveEntry) {
+	data, err := readFile(store, dockerRepositoriesFile)
+	if err != nil {
+		return
+	}
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(data, &repositories); err != nil {
+		return
+	}
+	for i := range entries {
+		if len(entries[i].RepoTags) > 0 || len(entries[i].Layers) == 0 {
+			continue
+		}
+		topLayer := path.Dir(cleanName(entries[i].Layers[len(entries[i].Layers)-1]))
+		for repo, tags := range repositories {
+			for tag, id := range tags {
+				if id == topLayer {
+					entries[i].RepoTags = append(entries[i].RepoTags, repo+":"+tag)
+				}
+			}
+		}
+	}
+}
+
+//selectDockerArchiveEntry picks image by its repository tag. Reference can be omitted when archive holds single image
+func selectDockerArchiveEntry(entries []dockerArchiveEntry, ref string) (dockerArchiveEntry, error) {
+	if len(entries) == 0 {
+		return dockerArchiveEntry{}, fmt.Errorf("docker archive does not contain any images")
+	}
+	if ref == "" {
+		if len(entries) == 1 {
+			return entries[0], nil
+		}
+		return dockerArchiveEntry{}, fmt.Errorf("docker archive contains %d images, please specify one of: %s", len(entries), strings.Join(dockerRepoTags(entries), ", "))
+	}
+	if !strings.Contains(path.Base(ref), ":") {
+		ref = ref + ":latest"
+	}
+	for _, e := range entries {
+		for _, t := range e.RepoTags {
+			if t == ref || strings.HasSuffix(t, "/"+ref) {
+				return e, nil
+			}
+		}
+	}
+	return dockerArchiveEntry{}, fmt.Errorf("image %s not found in docker archive. Available images: %s", ref, strings.Join(dockerRepoTags(entries), ", "))
+}
+
+func dockerRepoTags(entries []dockerArchiveEntry) []string {
+	tags := make([]string, 0)
+	for _, e := range entries {
+		tags = append(tags, e.RepoTags...)
+	}
+	return tags
+}
+
+//compressLayer turns docker save layer into registry blob. Uncompressed tar layers are gzipped into temporary file
+func compressLayer(store fileStore, name string) (distribution.Descriptor, string, error) {
+	rd, _, err := store.Open(name)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer rd.Close()
+	tmp, err := ioutil.TempFile("", "promoter-layer-")
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer tmp.Close()
+
+	digester := digest.Canonical.New()
+	w := io.MultiWriter(tmp, digester.Hash())
+	br := bufio.NewReader(rd)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		//Layer is already compressed
+		_, err = io.Copy(w, br)
+	} else {
+		gz := gzip.NewWriter(w)
+		_, err = io.Copy(gz, br)
+		if err == nil {
+			err = gz.Close()
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return distribution.Descriptor{}, "", err
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return distribution.Descriptor{}, "", err
+	}
+	return distribution.Descriptor{
+		MediaType: schema2.MediaTypeLayer,
+		Size:      fi.Size(),
+		Digest:    digester.Digest(),
+	}, tmp.Name(), nil
+}
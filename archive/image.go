@@ -0,0 +1,87 @@
+package archive
+
+import (
+	"io"
+	"os"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+)
+
+type blob struct {
+	descriptor distribution.Descriptor
+	open       func() (io.ReadCloser, error)
+}
+
+type manifestPayload struct {
+	digest    digest.Digest
+	mediaType string
+	payload   []byte
+}
+
+//image holds everything required to push archived image into a Registry.
+//Manifests are ordered so that referenced manifests come before manifests referencing them, the last one is tagged
+type image struct {
+	blobs     []blob
+	manifests []manifestPayload
+	tempFiles []string
+}
+
+func (img *image) addBlob(desc distribution.Descriptor, open func() (io.ReadCloser, error)) {
+	for _, b := range img.blobs {
+		if b.descriptor.Digest == desc.Digest {
+			return
+		}
+	}
+	img.blobs = append(img.blobs, blob{descriptor: desc, open: open})
+}
+
+//addStoreBlob registers blob kept in layout blobs directory. Blobs missing from the layout (e.g. excluded from bundle) can not be opened
+func (img *image) addStoreBlob(store fileStore, desc ociDescriptor) {
+	rd, size, err := store.Open(blobPath(desc.Digest))
+	if err != nil {
+		img.addBlob(distribution.Descriptor{MediaType: desc.MediaType, Size: desc.Size, Digest: desc.Digest}, nil)
+		return
+	}
+	rd.Close()
+	img.addBlob(distribution.Descriptor{
+		MediaType: desc.MediaType,
+		Size:      size,
+		Digest:    desc.Digest,
+	}, func() (io.ReadCloser, error) {
+		rd, _, err := store.Open(blobPath(desc.Digest))
+		return rd, err
+	})
+}
+
+func (img *image) addManifest(mediaType string, payload []byte) {
+	img.manifests = append(img.manifests, manifestPayload{
+		digest:    digest.FromBytes(payload),
+		mediaType: mediaType,
+		payload:   payload,
+	})
+}
+
+func (img *image) blob(d digest.Digest) (blob, bool) {
+	for _, b := range img.blobs {
+		if b.descriptor.Digest == d {
+			return b, true
+		}
+	}
+	return blob{}, false
+}
+
+func (img *image) digests() []digest.Digest {
+	digests := make([]digest.Digest, 0, len(img.blobs))
+	for _, b := range img.blobs {
+		digests = append(digests, b.descriptor.Digest)
+	}
+	return digests
+}
+
+//cleanup removes temporary files created while preparing image blobs
+func (img *image) cleanup() {
+	for _, f := range img.tempFiles {
+		os.Remove(f)
+	}
+}
@@ -0,0 +1,539 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/libtrust"
+	"github.com/vbaksa/promoter/backend"
+)
+
+const (
+	//LayoutPrefix marks OCI image layout directory used instead of a Registry, e.g. oci:/tmp/layout
+	LayoutPrefix = TransportOCI + ":"
+	//ArchivePrefix marks tarball of OCI image layout used instead of a Registry, e.g. oci-archive:/tmp/layout.tar
+	ArchivePrefix = TransportOCIArchive + ":"
+	ociLayout     = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+//IsLayout reports whether registry URL points to OCI image layout directory or tarball
+func IsLayout(url string) bool {
+	return strings.HasPrefix(url, LayoutPrefix) || strings.HasPrefix(url, ArchivePrefix)
+}
+
+//ClosableBackend is Backend holding resources until it is closed
+type ClosableBackend interface {
+	backend.Backend
+	io.Closer
+}
+
+//OpenBackend opens OCI image layout directory or tarball given by oci:/path or oci-archive:/path URL. Caller closes returned backend,
+//which writes changed tarball back
+func OpenBackend(url string) (ClosableBackend, error) {
+	//Failed open returns nil interface instead of nil pointer, so callers can tell there is nothing to close
+	switch {
+	case strings.HasPrefix(url, LayoutPrefix):
+		l, err := OpenLayout(strings.TrimPrefix(url, LayoutPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case strings.HasPrefix(url, ArchivePrefix):
+		a, err := OpenArchive(strings.TrimPrefix(url, ArchivePrefix))
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("%s is not an OCI image layout, expected %s/path or %s/path", url, LayoutPrefix, ArchivePrefix)
+}
+
+//Layout is Backend of OCI image layout directory. Images are listed by index.json under reference name annotation in repository:tag format,
+//index entries without repository are not listed. Blobs are shared by all repositories, so every layer of the layout can be mounted.
+//Schema1 manifests are stored converted into OCI image manifests, as OCI image layouts can not hold them
+type Layout struct {
+	root string
+	name string
+	key  libtrust.PrivateKey
+	//mutex guards index.json, modified and diffIDs
+	mutex    sync.Mutex
+	modified bool
+	//diffIDs caches uncompressed digests of layers by blob digest
+	diffIDs map[digest.Digest]digest.Digest
+}
+
+//OpenLayout opens OCI image layout directory. Missing directory is treated as empty layout and created once image is stored in it
+func OpenLayout(root string) (*Layout, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(abs); err == nil && !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", abs)
+	}
+	//Key signs schema1 manifests converted from image manifests, the way registries do
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Layout{root: abs, name: LayoutPrefix + abs, key: key, diffIDs: make(map[digest.Digest]digest.Digest)}, nil
+}
+
+//Name returns oci:/path of the layout
+func (l *Layout) Name() string {
+	return l.name
+}
+
+//Close is a no-op for directories
+func (l *Layout) Close() error {
+	return nil
+}
+
+func (l *Layout) blobFile(d digest.Digest) string {
+	return filepath.Join(l.root, filepath.FromSlash(blobPath(d)))
+}
+
+//readIndex reads index.json. Layout without index.json is empty. Caller holds the mutex
+func (l *Layout) readIndex() (*ociIndex, error) {
+	data, err := ioutil.ReadFile(filepath.Join(l.root, ociIndexFile))
+	if os.IsNotExist(err) {
+		return &ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []ociDescriptor{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ociIndexFile, err)
+	}
+	return &index, nil
+}
+
+//writeIndex replaces index.json. Layout marker file is written together with the first index. Caller holds the mutex
+func (l *Layout) writeIndex(index *ociIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.root, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(l.root, ociLayoutFile)); os.IsNotExist(err) {
+		if err := writeFileAtomic(filepath.Join(l.root, ociLayoutFile), []byte(ociLayout)); err != nil {
+			return err
+		}
+	}
+	l.modified = true
+	return writeFileAtomic(filepath.Join(l.root, ociIndexFile), data)
+}
+
+//splitRefName splits reference name annotation into repository and tag. Tag separator is the last colon after the last slash
+func splitRefName(name string) (string, string, bool) {
+	i := strings.LastIndex(name, ":")
+	if i <= 0 || i < strings.LastIndex(name, "/") {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+//Repositories lists repositories of index entries
+func (l *Layout) Repositories() ([]string, error) {
+	l.mutex.Lock()
+	index, err := l.readIndex()
+	l.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	repositories := make([]string, 0)
+	for _, m := range index.Manifests {
+		repository, _, ok := splitRefName(m.Annotations[annotationRefName])
+		if ok && !seen[repository] {
+			seen[repository] = true
+			repositories = append(repositories, repository)
+		}
+	}
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+//Tags lists tags of repository index entries
+func (l *Layout) Tags(repository string) ([]string, error) {
+	l.mutex.Lock()
+	index, err := l.readIndex()
+	l.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0)
+	for _, m := range index.Manifests {
+		if r, tag, ok := splitRefName(m.Annotations[annotationRefName]); ok && r == repository {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+//resolve returns descriptor of tag or digest reference. Digest references resolve to any manifest blob of the layout
+func (l *Layout) resolve(repository string, ref string) (ociDescriptor, error) {
+	if d, err := digest.ParseDigest(ref); err == nil {
+		fi, err := os.Stat(l.blobFile(d))
+		if err != nil {
+			return ociDescriptor{}, backend.NotFound("manifest " + ref + " not found")
+		}
+		return ociDescriptor{Digest: d, Size: fi.Size()}, nil
+	}
+	l.mutex.Lock()
+	index, err := l.readIndex()
+	l.mutex.Unlock()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] == repository+":"+ref {
+			return m, nil
+		}
+	}
+	return ociDescriptor{}, backend.NotFound("manifest " + repository + ":" + ref + " not found")
+}
+
+//RawManifest returns media type and payload of manifest blob
+func (l *Layout) RawManifest(repository string, ref string) (string, []byte, error) {
+	desc, err := l.resolve(repository, ref)
+	if err != nil {
+		return "", nil, err
+	}
+	payload, err := ioutil.ReadFile(l.blobFile(desc.Digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, backend.NotFound("manifest " + desc.Digest.String() + " not found")
+		}
+		return "", nil, err
+	}
+	mediaType := desc.MediaType
+	if len(mediaType) == 0 {
+		mediaType = backend.DetectMediaType(payload)
+	}
+	return mediaType, payload, nil
+}
+
+//ManifestDigest returns digest of manifest without reading it
+func (l *Layout) ManifestDigest(repository string, ref string) (digest.Digest, error) {
+	desc, err := l.resolve(repository, ref)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+//Manifest returns schema1 manifest of reference. Image manifests are converted into schema1 the way registries convert them
+//for clients not accepting schema2, image indexes are not converted
+func (l *Layout) Manifest(repository string, ref string) (*manifestV1.SignedManifest, error) {
+	mediaType, payload, err := l.RawManifest(repository, ref)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case manifestV1.MediaTypeSignedManifest, manifestV1.MediaTypeManifest:
+		var m manifestV1.SignedManifest
+		if err := m.UnmarshalJSON(payload); err != nil {
+			return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+		}
+		return &m, nil
+	case MediaTypeOCIManifest, schema2.MediaTypeManifest:
+		return l.convertManifest(repository, ref, payload)
+	}
+	return nil, fmt.Errorf("manifest %s:%s of media type %q can not be converted into schema1", repository, ref, mediaType)
+}
+
+//convertManifest builds signed schema1 manifest from image manifest and its config
+func (l *Layout) convertManifest(repository string, ref string, payload []byte) (*manifestV1.SignedManifest, error) {
+	var m ociManifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+	}
+	config, err := ioutil.ReadFile(l.blobFile(m.Config.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config %s: %v", m.Config.Digest, err)
+	}
+	if config, err = withHistory(config, len(m.Layers)); err != nil {
+		return nil, fmt.Errorf("failed to parse image config %s: %v", m.Config.Digest, err)
+	}
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := digest.ParseDigest(ref); err != nil {
+		if named, err = reference.WithTag(named, ref); err != nil {
+			return nil, err
+		}
+	}
+	builder := manifestV1.NewConfigManifestBuilder(layoutBlobs{l}, l.key, named, config)
+	for _, layer := range m.Layers {
+		if err := builder.AppendReference(distribution.Descriptor{MediaType: layer.MediaType, Size: layer.Size, Digest: layer.Digest}); err != nil {
+			return nil, err
+		}
+	}
+	built, err := builder.Build(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert image manifest into schema1: %v", err)
+	}
+	signed, ok := built.(*manifestV1.SignedManifest)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert image manifest into schema1")
+	}
+	return signed, nil
+}
+
+//withHistory adds history entry of every layer to image config without history. History is optional in OCI image config,
+//but schema1 manifest describes every layer by its history entry. Added entries carry no detail and are dropped again
+//when schema1 manifest is stored into a layout, so config without history keeps its content through schema1
+func withHistory(config []byte, layers int) ([]byte, error) {
+	var c map[string]interface{}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if history, ok := c["history"].([]interface{}); ok && len(history) > 0 {
+		return config, nil
+	}
+	history := make([]interface{}, layers)
+	for i := range history {
+		history[i] = map[string]interface{}{}
+	}
+	c["history"] = history
+	return json.Marshal(c)
+}
+
+//PutManifest stores signed schema1 manifest under tag converted into OCI image manifest and config. Layers have to be uploaded before.
+//Converted manifest has digest of its own, so schema1 manifest can not be stored by digest
+func (l *Layout) PutManifest(repository string, ref string, signedManifest *manifestV1.SignedManifest) error {
+	if _, err := digest.ParseDigest(ref); err == nil {
+		return fmt.Errorf("schema1 manifest can not be stored by digest %s in OCI image layout, it is converted into OCI image manifest", ref)
+	}
+	payload, err := l.imageFromSchema1(signedManifest)
+	if err != nil {
+		return fmt.Errorf("failed to convert schema1 manifest into OCI image manifest: %v", err)
+	}
+	return l.PutRawManifest(repository, ref, MediaTypeOCIManifest, payload)
+}
+
+//PutRawManifest stores manifest blob. Tag reference replaces index entry of repository:tag, digest reference only stores the blob.
+//Schema1 manifests are refused, OCI tools can not read them from a layout
+func (l *Layout) PutRawManifest(repository string, ref string, mediaType string, payload []byte) error {
+	if mediaType == manifestV1.MediaTypeSignedManifest || mediaType == manifestV1.MediaTypeManifest {
+		return fmt.Errorf("OCI image layout %s can not hold schema1 manifest of %s:%s, promote the image with push or tags to convert it", l.name, repository, ref)
+	}
+	d := digest.FromBytes(payload)
+	if refDigest, err := digest.ParseDigest(ref); err == nil && refDigest != d {
+		return fmt.Errorf("manifest digest %s does not match reference %s", d, ref)
+	}
+	if err := l.writeBlob(d, bytes.NewReader(payload)); err != nil {
+		return err
+	}
+	if _, err := digest.ParseDigest(ref); err == nil {
+		l.markModified()
+		return nil
+	}
+	name := repository + ":" + ref
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+	manifests := make([]ociDescriptor, 0, len(index.Manifests)+1)
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] != name {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, ociDescriptor{
+		MediaType:   mediaType,
+		Digest:      d,
+		Size:        int64(len(payload)),
+		Annotations: map[string]string{annotationRefName: name},
+	})
+	return l.writeIndex(index)
+}
+
+//DeleteManifest removes every repository tag pointing to manifest. Manifest blob is kept, as other repositories of the layout may use it
+func (l *Layout) DeleteManifest(repository string, d digest.Digest) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+	manifests := make([]ociDescriptor, 0, len(index.Manifests))
+	for _, m := range index.Manifests {
+		if r, _, ok := splitRefName(m.Annotations[annotationRefName]); ok && r == repository && m.Digest == d {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	if len(manifests) == len(index.Manifests) {
+		return backend.NotFound("manifest " + d.String() + " not found in repository " + repository)
+	}
+	index.Manifests = manifests
+	return l.writeIndex(index)
+}
+
+//HasLayer reports whether layout holds blob
+func (l *Layout) HasLayer(repository string, d digest.Digest) (bool, error) {
+	_, err := os.Stat(l.blobFile(d))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//LayerMetadata returns blob size
+func (l *Layout) LayerMetadata(repository string, d digest.Digest) (distribution.Descriptor, error) {
+	fi, err := os.Stat(l.blobFile(d))
+	if os.IsNotExist(err) {
+		return distribution.Descriptor{}, backend.NotFound("blob " + d.String() + " not found")
+	}
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{Digest: d, Size: fi.Size()}, nil
+}
+
+//DownloadLayer opens blob file
+func (l *Layout) DownloadLayer(repository string, d digest.Digest) (io.ReadCloser, error) {
+	f, err := os.Open(l.blobFile(d))
+	if os.IsNotExist(err) {
+		return nil, backend.NotFound("blob " + d.String() + " not found")
+	}
+	return f, err
+}
+
+//UploadLayer stores blob verified against digest
+func (l *Layout) UploadLayer(repository string, d digest.Digest, content io.Reader) error {
+	if err := l.writeBlob(d, content); err != nil {
+		return err
+	}
+	l.markModified()
+	return nil
+}
+
+//MountLayer reports whether blob exists, as blobs are shared by all repositories of the layout
+func (l *Layout) MountLayer(repository string, from string, d digest.Digest) (bool, error) {
+	return l.HasLayer(repository, d)
+}
+
+//writeBlob writes content into temporary file next to the blob and renames it once digest is verified,
+//so blob files are always complete
+func (l *Layout) writeBlob(d digest.Digest, content io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	dir := filepath.Dir(l.blobFile(d))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	verifier, err := digest.NewDigestVerifier(d)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = io.Copy(io.MultiWriter(tmp, verifier), content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content of blob %s does not match its digest", d)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.blobFile(d))
+}
+
+//markModified records that layout content changed
+func (l *Layout) markModified() {
+	l.mutex.Lock()
+	l.modified = true
+	l.mutex.Unlock()
+}
+
+//writeFileAtomic replaces file by renaming temporary file written next to it
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+//layoutBlobs lets schema1 manifest builder read blobs of the layout and store empty layer it references.
+//Empty layer is only needed while converted manifest is used, so it does not mark layout modified
+type layoutBlobs struct {
+	l *Layout
+}
+
+func (b layoutBlobs) Stat(ctx context.Context, d digest.Digest) (distribution.Descriptor, error) {
+	desc, err := b.l.LayerMetadata("", d)
+	if backend.IsNotFound(err) {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return desc, err
+}
+
+func (b layoutBlobs) Get(ctx context.Context, d digest.Digest) ([]byte, error) {
+	return ioutil.ReadFile(b.l.blobFile(d))
+}
+
+func (b layoutBlobs) Open(ctx context.Context, d digest.Digest) (distribution.ReadSeekCloser, error) {
+	return os.Open(b.l.blobFile(d))
+}
+
+func (b layoutBlobs) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	d := digest.FromBytes(p)
+	if err := b.l.writeBlob(d, bytes.NewReader(p)); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{MediaType: mediaType, Size: int64(len(p)), Digest: d}, nil
+}
+
+func (b layoutBlobs) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	return nil, distribution.ErrUnsupported
+}
+
+func (b layoutBlobs) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	return nil, distribution.ErrUnsupported
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/vbaksa/promoter/backend"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "promoter-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLayoutConformance(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := OpenLayout(filepath.Join(dir, "layout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Conformance(l, "acme/app"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveConformance(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "layout.tar")
+	a, err := OpenArchive(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Conformance(a, "acme/app"); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("kept layer")
+	layer := digest.FromBytes(content)
+	if err := a.UploadLayer("acme/app", layer, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenArchive(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if exists, err := reopened.HasLayer("acme/app", layer); err != nil || !exists {
+		t.Fatalf("layer %s was not written into archive: %v", layer, err)
+	}
+}
+
+func TestLayoutManifestConvertedIntoSchema1(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("layer")
+	layer := digest.FromBytes(content)
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + layer.String() + `"]},` +
+		`"history":[{"created_by":"ADD layer"},{"created_by":"CMD app","empty_layer":true}]}`)
+	configDigest := digest.FromBytes(config)
+	for d, blob := range map[digest.Digest][]byte{layer: content, configDigest: config} {
+		if err := l.UploadLayer("acme/app", d, bytes.NewReader(blob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"%s","size":%d,"digest":"%s"},"layers":[{"mediaType":"%s","size":%d,"digest":"%s"}]}`,
+		MediaTypeOCIManifest, "application/vnd.oci.image.config.v1+json", len(config), configDigest, schema2.MediaTypeLayer, len(content), layer))
+	if err := l.PutRawManifest("acme/app", "1.0", MediaTypeOCIManifest, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := l.Manifest("acme/app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "acme/app" || m.Tag != "1.0" || m.Architecture != "amd64" {
+		t.Fatalf("converted manifest is %s:%s of %s", m.Name, m.Tag, m.Architecture)
+	}
+	if len(m.FSLayers) != 2 || m.FSLayers[1].BlobSum != layer {
+		t.Fatalf("converted manifest layers are %v, expected empty layer on top of %s", m.FSLayers, layer)
+	}
+	if exists, err := l.HasLayer("acme/app", m.FSLayers[0].BlobSum); err != nil || !exists {
+		t.Fatalf("empty layer %s of converted manifest is not stored: %v", m.FSLayers[0].BlobSum, err)
+	}
+	if d, err := l.ManifestDigest("acme/app", "1.0"); err != nil || d != digest.FromBytes(payload) {
+		t.Fatalf("manifest digest is %s, expected digest of stored manifest %s: %v", d, digest.FromBytes(payload), err)
+	}
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/vbaksa/promoter/backend"
+)
+
+const (
+	ociIndexFile = "index.json"
+	//MediaTypeOCIManifest is OCI image manifest media type
+	MediaTypeOCIManifest = backend.MediaTypeOCIManifest
+	//MediaTypeOCIIndex is OCI image index media type
+	MediaTypeOCIIndex = backend.MediaTypeOCIIndex
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+//ociDescriptor is distribution.Descriptor extended with OCI annotations
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType,omitempty"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+//ociIndex covers both OCI image index and Docker manifest list
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+//ociManifest covers both OCI image manifest and Docker schema2 manifest
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+func blobPath(d digest.Digest) string {
+	return path.Join("blobs", string(d.Algorithm()), d.Hex())
+}
+
+func readOCI(store fileStore, ref string) (*image, error) {
+	data, err := readFile(store, ociIndexFile)
+	if err != nil {
+		return nil, fmt.Errorf("not an OCI image layout: %v", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ociIndexFile, err)
+	}
+	desc, err := selectOCIManifest(index.Manifests, ref)
+	if err != nil {
+		return nil, err
+	}
+	img := &image{}
+	if err := img.addOCIManifest(store, desc); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+//selectOCIManifest picks index entry by its reference name annotation. Reference can be omitted when layout holds single image
+func selectOCIManifest(manifests []ociDescriptor, ref string) (ociDescriptor, error) {
+	if len(manifests) == 0 {
+		return ociDescriptor{}, fmt.Errorf("OCI layout does not contain any images")
+	}
+	if ref == "" {
+		if len(manifests) == 1 {
+			return manifests[0], nil
+		}
+		return ociDescriptor{}, fmt.Errorf("OCI layout contains %d images, please specify one of: %s", len(manifests), strings.Join(ociRefNames(manifests), ", "))
+	}
+	for _, m := range manifests {
+		name := m.Annotations[annotationRefName]
+		if name == ref || strings.HasSuffix(name, ":"+ref) || string(m.Digest) == ref {
+			return m, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("image %s not found in OCI layout. Available images: %s", ref, strings.Join(ociRefNames(manifests), ", "))
+}
+
+func ociRefNames(manifests []ociDescriptor) []string {
+	names := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		if name, ok := m.Annotations[annotationRefName]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, string(m.Digest))
+		}
+	}
+	return names
+}
+
+//addOCIManifest walks manifest (or index) blob and registers all blobs and manifests it references
+func (img *image) addOCIManifest(store fileStore, desc ociDescriptor) error {
+	payload, err := readFile(store, blobPath(desc.Digest))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest %s: %v", desc.Digest, err)
+	}
+	if digest.FromBytes(payload) != desc.Digest {
+		return fmt.Errorf("manifest %s content does not match its digest", desc.Digest)
+	}
+	switch desc.MediaType {
+	case MediaTypeOCIIndex, manifestlist.MediaTypeManifestList:
+		var index ociIndex
+		if err := json.Unmarshal(payload, &index); err != nil {
+			return fmt.Errorf("failed to parse image index %s: %v", desc.Digest, err)
+		}
+		for _, m := range index.Manifests {
+			if err := img.addOCIManifest(store, m); err != nil {
+				return err
+			}
+		}
+	case MediaTypeOCIManifest, schema2.MediaTypeManifest:
+		var m ociManifest
+		if err := json.Unmarshal(payload, &m); err != nil {
+			return fmt.Errorf("failed to parse image manifest %s: %v", desc.Digest, err)
+		}
+		img.addStoreBlob(store, m.Config)
+		for _, l := range m.Layers {
+			//Foreign layers are pulled by clients from their own URLs
+			if len(l.URLs) > 0 {
+				continue
+			}
+			img.addStoreBlob(store, l)
+		}
+	case manifestV1.MediaTypeSignedManifest, manifestV1.MediaTypeManifest:
+		var m manifestV1.SignedManifest
+		if err := m.UnmarshalJSON(payload); err != nil {
+			return fmt.Errorf("failed to parse image manifest %s: %v", desc.Digest, err)
+		}
+		for _, l := range m.FSLayers {
+			img.addStoreBlob(store, ociDescriptor{MediaType: manifestV1.MediaTypeManifestLayer, Digest: l.BlobSum})
+		}
+	default:
+		return fmt.Errorf("manifest %s has unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+	img.addManifest(desc.MediaType, payload)
+	return nil
+}
@@ -0,0 +1,146 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Archive is Backend of OCI image layout tarball. Tarball is unpacked into temporary directory when opened
+//and written back by Close when the layout changed
+type Archive struct {
+	*Layout
+	path   string
+	dir    string
+	closed bool
+}
+
+//OpenArchive unpacks OCI image layout tarball. Missing tarball is treated as empty layout and created by Close once image is stored in it
+func OpenArchive(name string) (*Archive, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "promoter-oci-")
+	if err != nil {
+		return nil, err
+	}
+	if err := unpackArchive(abs, dir); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to unpack %s: %v", abs, err)
+	}
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	layout.name = ArchivePrefix + abs
+	return &Archive{Layout: layout, path: abs, dir: dir}, nil
+}
+
+//Close writes changed layout into the tarball and removes unpacked layout. Archive is written once, later calls do nothing
+func (a *Archive) Close() error {
+	a.mutex.Lock()
+	modified, closed := a.modified, a.closed
+	a.closed = true
+	a.mutex.Unlock()
+	if closed {
+		return nil
+	}
+	defer os.RemoveAll(a.dir)
+	if !modified {
+		return nil
+	}
+	if err := packArchive(a.dir, a.path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", a.path, err)
+	}
+	return nil
+}
+
+//unpackArchive extracts regular files of uncompressed tarball into directory. Entries leaving the directory are skipped
+func unpackArchive(name string, dir string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("not an uncompressed tar archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(cleanName(hdr.Name)))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//packArchive writes files of layout directory into tarball. Tarball is replaced only once it was written completely
+func packArchive(dir string, name string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".promoter-oci-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tw := tar.NewWriter(tmp)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		//Temporary files of interrupted uploads are not part of the layout
+		if strings.HasPrefix(filepath.Base(rel), ".") {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := tw.WriteHeader(tarHeader(rel, fi.Size())); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+	"github.com/dustin/go-humanize"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/progressbar"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+//ensureBlobs makes sure all image blobs exist on destination image. Existing blobs are skipped,
+//blobs excluded from the archive are mounted from repositories recorded for them and the rest is uploaded
+func ensureBlobs(destHub backend.Backend, destImage string, img *image, excluded map[digest.Digest][]string) error {
+	fmt.Println("Optimising upload...")
+	missing, totalSaved := layer.MissingDigests(destHub, destImage, img.digests(), progress.Console{})
+	fmt.Println()
+	if totalSaved > 100 {
+		fmt.Printf("Some layers already exist on Remote Registry. Skipping around %s of layer data\n", humanize.Bytes(uint64(totalSaved)))
+	}
+	upload := make([]digest.Digest, 0, len(missing))
+	for _, d := range missing {
+		if b, _ := img.blob(d); b.open != nil {
+			upload = append(upload, d)
+			continue
+		}
+		repositories, ok := excluded[d]
+		if !ok {
+			return fmt.Errorf("blob %s is missing from archive", d)
+		}
+		if !mountExcludedBlob(destHub, destImage, d, repositories) {
+			return fmt.Errorf("blob %s was excluded from bundle but does not exist on Destination Registry", d)
+		}
+		fmt.Println("Mounted layer excluded from bundle: " + d)
+	}
+	return uploadImageBlobs(destHub, destImage, img, upload)
+}
+
+func mountExcludedBlob(destHub backend.Backend, destImage string, d digest.Digest, repositories []string) bool {
+	for _, from := range repositories {
+		if from == destImage {
+			continue
+		}
+		mounted, err := destHub.MountLayer(destImage, from, d)
+		if err == nil && mounted {
+			return true
+		}
+	}
+	return false
+}
+
+//uploadImageBlobs uploads specified image blobs concurrently while displaying transfer progress
+func uploadImageBlobs(destHub backend.Backend, destImage string, img *image, digests []digest.Digest) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	var totalSize int64
+	for _, d := range digests {
+		b, _ := img.blob(d)
+		totalSize = totalSize + b.descriptor.Size
+	}
+	fmt.Printf("Going to upload around %s of layer data\n", humanize.Bytes(uint64(totalSize)))
+	fmt.Println()
+	fmt.Println("Uploading layers")
+	fmt.Println()
+
+	done := make(chan error)
+	total := &progressbar.Counter{}
+	for _, d := range digests {
+		b, _ := img.blob(d)
+		go func(b blob) {
+			done <- uploadBlob(destHub, destImage, b, total)
+		}(b)
+	}
+	bar := pb.New64(totalSize).SetUnits(pb.U_BYTES)
+	bar.Start()
+	sampler := total.Sample(progressbar.SampleInterval, func(n int64) {
+		bar.Add64(n)
+	})
+	var uploadErr error
+	for i := 0; i < len(digests); i++ {
+		if err := <-done; err != nil {
+			uploadErr = err
+		}
+	}
+	sampler.Stop()
+	bar.Finish()
+	if uploadErr == nil {
+		fmt.Println("Finished uploading layers")
+	}
+	return uploadErr
+}
+
+func uploadBlob(destHub backend.Backend, destImage string, b blob, total *progressbar.Counter) error {
+	reader, err := b.open()
+	if err != nil {
+		return fmt.Errorf("cannot read blob %s: %v", b.descriptor.Digest, err)
+	}
+	defer reader.Close()
+	rd := &progressbar.PassThru{ReadCloser: reader, Total: total}
+	if err := destHub.UploadLayer(destImage, b.descriptor.Digest, rd); err != nil {
+		return fmt.Errorf("cannot upload blob %s: %v", b.descriptor.Digest, err)
+	}
+	return nil
+}
+
+//putImageManifests submits referenced manifests by digest and tags the top level one
+func putImageManifests(destHub backend.Backend, destImage string, destTag string, img *image) error {
+	for i, m := range img.manifests {
+		reference := m.digest.String()
+		if i == len(img.manifests)-1 {
+			reference = destTag
+		}
+		var err error
+		switch m.mediaType {
+		case manifestV1.MediaTypeSignedManifest, manifestV1.MediaTypeManifest:
+			err = putSignedManifest(destHub, destImage, reference, m.payload)
+		default:
+			err = destHub.PutRawManifest(destImage, reference, m.mediaType, m.payload)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//putSignedManifest re-signs schema1 manifest for destination image name and tag before submitting it
+func putSignedManifest(hub backend.Backend, repository, tag string, payload []byte) error {
+	var src manifestV1.SignedManifest
+	if err := src.UnmarshalJSON(payload); err != nil {
+		return err
+	}
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return err
+	}
+	signed, err := manifestV1.Sign(&manifestV1.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         repository,
+		Tag:          tag,
+		Architecture: src.Architecture,
+		FSLayers:     src.FSLayers,
+		History:      src.History,
+	}, key)
+	if err != nil {
+		return err
+	}
+	return hub.PutManifest(repository, tag, signed)
+}
@@ -0,0 +1,94 @@
+package archive_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/archive"
+	"github.com/vbaksa/promoter/promote"
+	"github.com/vbaksa/promoter/registryfs"
+)
+
+//TestLayoutRoundTripThroughRegistry promotes image from layout into registry, which stores it as schema1, and back into another layout.
+//Image config is written the way schema1 conversion writes it, so manifest and config digests round-trip
+func TestLayoutRoundTripThroughRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promoter-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := archive.OpenLayout(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("layer content")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(content)
+	gz.Close()
+	layer := digest.FromBytes(compressed.Bytes())
+	config := []byte(`{"architecture":"amd64","container_config":{"Cmd":["CMD app"]},"created":"2020-01-01T00:00:00Z",` +
+		`"history":[{"created":"2019-12-31T00:00:00Z","created_by":"ADD layer"},{"created":"2020-01-01T00:00:00Z","created_by":"CMD app","empty_layer":true}],` +
+		`"os":"linux","rootfs":{"type":"layers","diff_ids":["` + digest.FromBytes(content).String() + `"]}}`)
+	configDigest := digest.FromBytes(config)
+	for d, blob := range map[digest.Digest][]byte{layer: compressed.Bytes(), configDigest: config} {
+		if err := src.UploadLayer("acme/app", d, bytes.NewReader(blob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":%d},`+
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"%s","size":%d}]}`,
+		archive.MediaTypeOCIManifest, configDigest, len(config), layer, compressed.Len()))
+	if err := src.PutRawManifest("acme/app", "1.0", archive.MediaTypeOCIManifest, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	hops := []struct {
+		from string
+		to   string
+	}{
+		{archive.LayoutPrefix + filepath.Join(dir, "src"), registryfs.Prefix + filepath.Join(dir, "registry")},
+		{registryfs.Prefix + filepath.Join(dir, "registry"), archive.LayoutPrefix + filepath.Join(dir, "dest")},
+	}
+	for _, hop := range hops {
+		_, err := promote.Image(context.Background(), promote.ImageOptions{
+			Source:           promote.Registry{URL: hop.from},
+			SourceImage:      "acme/app",
+			SourceTag:        "1.0",
+			Destination:      promote.Registry{URL: hop.to},
+			DestinationImage: "acme/app",
+			DestinationTag:   "1.0",
+		})
+		if err != nil {
+			t.Fatalf("promotion from %s to %s failed: %v", hop.from, hop.to, err)
+		}
+	}
+
+	dest, err := archive.OpenLayout(filepath.Join(dir, "dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, stored, err := dest.RawManifest("acme/app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != archive.MediaTypeOCIManifest {
+		t.Fatalf("layout holds manifest of media type %q, expected OCI image manifest", mediaType)
+	}
+	if !bytes.Equal(stored, payload) {
+		t.Fatalf("round-tripped manifest\n%s\ndiffers from original\n%s", stored, payload)
+	}
+	reader, err := dest.DownloadLayer("acme/app", configDigest)
+	if err != nil {
+		t.Fatalf("config %s did not round-trip: %v", configDigest, err)
+	}
+	reader.Close()
+}
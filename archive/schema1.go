@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+)
+
+//Media types of image config and layers written into layouts
+const (
+	mediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+//v1Fields are schema1 bookkeeping fields of v1Compatibility, they are not part of image config
+var v1Fields = []string{"id", "parent", "parent_id", "layer_id", "Size", "throwaway"}
+
+//v1Compatibility holds fields of schema1 history entry describing how its layer was created
+type v1Compatibility struct {
+	Created         string `json:"created"`
+	Author          string `json:"author"`
+	Comment         string `json:"comment"`
+	ContainerConfig struct {
+		Cmd []string
+	} `json:"container_config"`
+	ThrowAway bool `json:"throwaway"`
+}
+
+type ociHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+type ociRootFS struct {
+	Type    string          `json:"type"`
+	DiffIDs []digest.Digest `json:"diff_ids"`
+}
+
+//imageFromSchema1 converts signed schema1 manifest into OCI image manifest, reversing conversion done by Manifest.
+//Image config is the top v1Compatibility without schema1 fields, with rootfs and history rebuilt from history entries.
+//History entries without any detail, which Manifest adds to configs without history, are dropped, so such config keeps its content.
+//Layers must be stored in the layout already, as their uncompressed digests are needed for rootfs. Config blob is stored by the call
+func (l *Layout) imageFromSchema1(m *manifestV1.SignedManifest) ([]byte, error) {
+	if len(m.History) == 0 || len(m.History) != len(m.FSLayers) {
+		return nil, fmt.Errorf("schema1 manifest has %d history entries for %d layers", len(m.History), len(m.FSLayers))
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config of schema1 manifest: %v", err)
+	}
+	rootFS := ociRootFS{Type: "layers", DiffIDs: []digest.Digest{}}
+	layers := make([]ociDescriptor, 0, len(m.FSLayers))
+	history := make([]ociHistory, 0, len(m.History))
+	detailed := false
+	//Schema1 lists the top layer first
+	for i := len(m.History) - 1; i >= 0; i-- {
+		var v1 v1Compatibility
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &v1); err != nil {
+			return nil, fmt.Errorf("failed to parse history of schema1 manifest: %v", err)
+		}
+		h := ociHistory{
+			CreatedBy:  strings.Join(v1.ContainerConfig.Cmd, " "),
+			Author:     v1.Author,
+			Comment:    v1.Comment,
+			EmptyLayer: v1.ThrowAway,
+		}
+		if created, err := time.Parse(time.RFC3339Nano, v1.Created); err == nil && !created.IsZero() {
+			h.Created = v1.Created
+		}
+		detailed = detailed || h != ociHistory{}
+		history = append(history, h)
+		if v1.ThrowAway {
+			continue
+		}
+		layer, diffID, err := l.layerDescriptor(m.FSLayers[i].BlobSum)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+		rootFS.DiffIDs = append(rootFS.DiffIDs, diffID)
+	}
+	for _, field := range v1Fields {
+		delete(config, field)
+	}
+	var err error
+	if _, ok := config["architecture"]; !ok && len(m.Architecture) > 0 {
+		if config["architecture"], err = json.Marshal(m.Architecture); err != nil {
+			return nil, err
+		}
+	}
+	if config["rootfs"], err = json.Marshal(rootFS); err != nil {
+		return nil, err
+	}
+	if detailed {
+		if config["history"], err = json.Marshal(history); err != nil {
+			return nil, err
+		}
+	}
+	configPayload, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	configDigest := digest.FromBytes(configPayload)
+	if err := l.writeBlob(configDigest, bytes.NewReader(configPayload)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        ociDescriptor{MediaType: mediaTypeOCIConfig, Digest: configDigest, Size: int64(len(configPayload))},
+		Layers:        layers,
+	})
+}
+
+//layerDescriptor describes stored layer blob and returns digest of its uncompressed content.
+//Layers which are not gzip compressed are described as uncompressed tar, their uncompressed digest is the blob digest
+func (l *Layout) layerDescriptor(d digest.Digest) (ociDescriptor, digest.Digest, error) {
+	f, err := os.Open(l.blobFile(d))
+	if os.IsNotExist(err) {
+		return ociDescriptor{}, "", fmt.Errorf("layer %s of schema1 manifest is not stored in the layout", d)
+	}
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	desc := ociDescriptor{MediaType: mediaTypeOCILayerGzip, Digest: d, Size: fi.Size()}
+	l.mutex.Lock()
+	diffID, ok := l.diffIDs[d]
+	l.mutex.Unlock()
+	if !ok {
+		gz, err := gzip.NewReader(f)
+		switch err {
+		case nil:
+			if diffID, err = digest.FromReader(gz); err != nil {
+				return ociDescriptor{}, "", fmt.Errorf("failed to decompress layer %s: %v", d, err)
+			}
+		case gzip.ErrHeader, io.EOF, io.ErrUnexpectedEOF:
+			diffID = d
+		default:
+			return ociDescriptor{}, "", err
+		}
+		l.mutex.Lock()
+		l.diffIDs[d] = diffID
+		l.mutex.Unlock()
+	}
+	if diffID == d {
+		desc.MediaType = mediaTypeOCILayer
+	}
+	return desc, diffID, nil
+}
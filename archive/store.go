@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//fileStore provides read access to files of an image layout kept either in a directory or in a tarball
+type fileStore interface {
+	Open(name string) (io.ReadCloser, int64, error)
+	Close() error
+}
+
+type dirStore struct {
+	root string
+}
+
+func openDirStore(root string) (*dirStore, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &dirStore{root: root}, nil
+}
+
+//Open opens file relative to the layout root
+func (d *dirStore) Open(name string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.Join(d.root, filepath.FromSlash(cleanName(name))))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+//Close is a no-op for directories
+func (d *dirStore) Close() error {
+	return nil
+}
+
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+//tarStore indexes uncompressed tarball once so that files can be read later without extracting them
+type tarStore struct {
+	file    *os.File
+	entries map[string]tarEntry
+}
+
+func openTarStore(name string) (*tarStore, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]tarEntry)
+	links := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s is not an uncompressed tar archive: %v", name, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			//tar reader does not buffer, so current file position is the beginning of entry data
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			entries[cleanName(hdr.Name)] = tarEntry{offset: offset, size: hdr.Size}
+		case tar.TypeSymlink, tar.TypeLink:
+			//docker save stores duplicate layers as links to the first copy
+			target := hdr.Linkname
+			if hdr.Typeflag == tar.TypeSymlink {
+				target = path.Join(path.Dir(cleanName(hdr.Name)), hdr.Linkname)
+			}
+			links[cleanName(hdr.Name)] = cleanName(target)
+		}
+	}
+	for name, target := range links {
+		if e, ok := entries[target]; ok {
+			entries[name] = e
+		}
+	}
+	return &tarStore{file: f, entries: entries}, nil
+}
+
+//Open returns reader for the tarball entry
+func (t *tarStore) Open(name string) (io.ReadCloser, int64, error) {
+	e, ok := t.entries[cleanName(name)]
+	if !ok {
+		return nil, 0, fmt.Errorf("file %s not found in archive %s", name, t.file.Name())
+	}
+	return ioutil.NopCloser(io.NewSectionReader(t.file, e.offset, e.size)), e.size, nil
+}
+
+//Close closes underlying tarball
+func (t *tarStore) Close() error {
+	return t.file.Close()
+}
+
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+func readFile(store fileStore, name string) ([]byte, error) {
+	rd, _, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return ioutil.ReadAll(rd)
+}
@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vbaksa/promoter/progress"
+)
+
+//Unbundle holds bundle import structure
+type Unbundle struct {
+	Bundle       string
+	DestRegistry string
+	DestUsername string
+	DestPassword string
+	DestInsecure bool
+	//Progress receives status messages, Console when nil
+	Progress progress.Progress
+}
+
+type unbundleImage struct {
+	repository string
+	tag        string
+	img        *image
+}
+
+//UnbundleImages pushes all bundled images into Destination Registry.
+//Tags are published only after blobs of every image, including the ones excluded from the bundle, are confirmed to exist.
+//Images failed to publish are reported by returned error
+func (u *Unbundle) UnbundleImages() error {
+	p := progress.Or(u.Progress)
+	p.Printf("Preparing bundle import\n")
+	store, err := openTarStore(u.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle: %v", err)
+	}
+	defer store.Close()
+	metadata := &bundleMetadata{}
+	if data, err := readFile(store, bundleFile); err == nil {
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return fmt.Errorf("failed to parse bundle metadata: %v", err)
+		}
+	}
+	data, err := readFile(store, ociIndexFile)
+	if err != nil {
+		return fmt.Errorf("bundle does not contain image index: %v", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("failed to parse bundle image index: %v", err)
+	}
+
+	images := make([]unbundleImage, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		name, ok := desc.Annotations[annotationRefName]
+		if !ok {
+			p.Printf("Skipping bundled manifest without image name: %s\n", desc.Digest)
+			continue
+		}
+		img := &image{}
+		if err := img.addOCIManifest(store, desc); err != nil {
+			return fmt.Errorf("failed to read bundled image %s: %v", name, err)
+		}
+		repository, tag := splitImageTag(name)
+		images = append(images, unbundleImage{repository: repository, tag: tag, img: img})
+	}
+	p.Printf("Bundle contains %d images. Blobs excluded from bundle: %d\n", len(images), len(metadata.Excluded))
+
+	destHub, err := connectDestination(u.DestRegistry, u.DestUsername, u.DestPassword, u.DestInsecure)
+	if err != nil {
+		return err
+	}
+	defer destHub.Close()
+	for _, i := range images {
+		p.Printf("Verifying layers of %s:%s\n", i.repository, i.tag)
+		if err := ensureBlobs(destHub, i.repository, i.img, metadata.Excluded); err != nil {
+			return fmt.Errorf("bundle can not be imported, no tags were published: %v", err)
+		}
+	}
+	failed := make([]string, 0)
+	for _, i := range images {
+		if err := putImageManifests(destHub, i.repository, i.tag, i.img); err != nil {
+			p.Printf("Failed to publish image %s:%s. Error: %s\n", i.repository, i.tag, err.Error())
+			failed = append(failed, i.repository+":"+i.tag)
+			continue
+		}
+		p.Printf("Published %s:%s\n", i.repository, i.tag)
+	}
+	if err := destHub.Close(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to publish images: %s", strings.Join(failed, ", "))
+	}
+	p.Printf("All done!\n")
+	return nil
+}
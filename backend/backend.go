@@ -0,0 +1,63 @@
+//Package backend abstracts storage images are promoted from and into, so any pair of backends can be used as Source and Destination
+package backend
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//Backend stores repositories of images. Implementations have to be safe for concurrent use.
+//Missing manifests and layers are reported by *registry.HttpStatusError with status 404, so IsNotFound recognizes them
+type Backend interface {
+	//Name identifies backend storage. Backends having the same name share layers, so layers can be mounted between their repositories
+	Name() string
+	//Repositories lists stored repositories
+	Repositories() ([]string, error)
+	//Tags lists repository tags
+	Tags(repository string) ([]string, error)
+	//Manifest returns schema1 manifest of tag or digest reference
+	Manifest(repository string, reference string) (*manifestV1.SignedManifest, error)
+	//RawManifest returns media type and payload of tag or digest reference exactly as stored
+	RawManifest(repository string, reference string) (string, []byte, error)
+	//ManifestDigest returns digest of stored manifest without downloading it
+	ManifestDigest(repository string, reference string) (digest.Digest, error)
+	//PutManifest stores manifest under tag reference
+	PutManifest(repository string, reference string, signedManifest *manifestV1.SignedManifest) error
+	//PutRawManifest stores manifest payload of media type under tag or digest reference exactly as given
+	PutRawManifest(repository string, reference string, mediaType string, payload []byte) error
+	//DeleteManifest deletes manifest and every tag pointing to it
+	DeleteManifest(repository string, digest digest.Digest) error
+	//HasLayer reports whether repository holds layer
+	HasLayer(repository string, digest digest.Digest) (bool, error)
+	//LayerMetadata returns layer size without downloading it
+	LayerMetadata(repository string, digest digest.Digest) (distribution.Descriptor, error)
+	//DownloadLayer returns layer content, caller closes the reader
+	DownloadLayer(repository string, digest digest.Digest) (io.ReadCloser, error)
+	//UploadLayer stores layer content verified against digest
+	UploadLayer(repository string, digest digest.Digest, content io.Reader) error
+	//MountLayer links layer of another repository of the same backend instead of uploading it. Returns false when backend refused the mount
+	MountLayer(repository string, from string, digest digest.Digest) (bool, error)
+}
+
+//NotFound returns error of missing manifest or layer, which IsNotFound recognizes. Backends not talking to a registry report missing content by it
+func NotFound(message string) error {
+	return &registry.HttpStatusError{
+		Response: &http.Response{StatusCode: http.StatusNotFound, Status: http.StatusText(http.StatusNotFound)},
+		Body:     []byte(message),
+	}
+}
+
+//IsNotFound reports whether backend error means missing manifest or layer
+func IsNotFound(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	statusErr, ok := err.(*registry.HttpStatusError)
+	return ok && statusErr.Response.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/libtrust"
+)
+
+//Conformance checks that backend behaves the way promotion relies on. Every backend is expected to pass it.
+//Small test image is pushed into repository, read back and deleted again
+func Conformance(b Backend, repository string) error {
+	tag := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+	content := []byte("promoter conformance " + tag)
+	layer := digest.FromBytes(content)
+
+	if exists, err := b.HasLayer(repository, layer); err != nil && !IsNotFound(err) {
+		return fmt.Errorf("layer check failed: %v", err)
+	} else if exists {
+		return fmt.Errorf("layer %s exists before upload", layer)
+	}
+	if err := b.UploadLayer(repository, layer, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("layer upload failed: %v", err)
+	}
+	if exists, err := b.HasLayer(repository, layer); err != nil || !exists {
+		return fmt.Errorf("uploaded layer %s does not exist: %v", layer, err)
+	}
+	metadata, err := b.LayerMetadata(repository, layer)
+	if err != nil {
+		return fmt.Errorf("layer metadata failed: %v", err)
+	}
+	if metadata.Size != int64(len(content)) {
+		return fmt.Errorf("layer size is %d, expected %d", metadata.Size, len(content))
+	}
+	reader, err := b.DownloadLayer(repository, layer)
+	if err != nil {
+		return fmt.Errorf("layer download failed: %v", err)
+	}
+	downloaded, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("layer download failed: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		return fmt.Errorf("downloaded layer %s differs from uploaded one", layer)
+	}
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return err
+	}
+	signed, err := manifestV1.Sign(&manifestV1.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         repository,
+		Tag:          tag,
+		Architecture: "amd64",
+		FSLayers:     []manifestV1.FSLayer{{BlobSum: layer}},
+		History:      []manifestV1.History{{V1Compatibility: `{"id":"` + layer.Hex() + `"}`}},
+	}, key)
+	if err != nil {
+		return err
+	}
+	if err := b.PutManifest(repository, tag, signed); err != nil {
+		return fmt.Errorf("manifest upload failed: %v", err)
+	}
+	stored, err := b.Manifest(repository, tag)
+	if err != nil {
+		return fmt.Errorf("manifest download failed: %v", err)
+	}
+	if len(stored.FSLayers) != 1 || stored.FSLayers[0].BlobSum != layer {
+		return fmt.Errorf("downloaded manifest differs from uploaded one")
+	}
+	mediaType, payload, err := b.RawManifest(repository, tag)
+	if err != nil {
+		return fmt.Errorf("raw manifest download failed: %v", err)
+	}
+	refs, err := ParseReferences(mediaType, payload)
+	if err != nil {
+		return fmt.Errorf("raw manifest of media type %q is not readable: %v", mediaType, err)
+	}
+	//Backends unable to hold schema1 store it converted, with image config next to the layer
+	if !referencesBlob(refs, layer) {
+		return fmt.Errorf("downloaded raw manifest does not reference uploaded layer %s", layer)
+	}
+	manifestDigest, err := b.ManifestDigest(repository, tag)
+	if err != nil {
+		return fmt.Errorf("manifest digest failed: %v", err)
+	}
+	if _, err := b.Manifest(repository, manifestDigest.String()); err != nil {
+		return fmt.Errorf("manifest download by digest %s failed: %v", manifestDigest, err)
+	}
+	tags, err := b.Tags(repository)
+	if err != nil {
+		return fmt.Errorf("tag listing failed: %v", err)
+	}
+	if !contains(tags, tag) {
+		return fmt.Errorf("tag %s is not listed", tag)
+	}
+	if _, err := b.Manifest(repository, "missing-"+tag); !IsNotFound(err) {
+		return fmt.Errorf("missing manifest is not reported as not found: %v", err)
+	}
+
+	if err := b.DeleteManifest(repository, manifestDigest); err != nil {
+		return fmt.Errorf("manifest delete failed: %v", err)
+	}
+	if _, err := b.ManifestDigest(repository, tag); !IsNotFound(err) {
+		return fmt.Errorf("tag %s still exists after its manifest was deleted: %v", tag, err)
+	}
+	return conformanceRaw(b, repository, tag+"-raw", layer, int64(len(content)))
+}
+
+//conformanceRaw checks that schema2 manifest referencing uploaded layer is stored as given, so its digest does not change
+func conformanceRaw(b Backend, repository string, tag string, layer digest.Digest, layerSize int64) error {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + layer.String() + `"]}}`)
+	configDigest := digest.FromBytes(config)
+	if err := b.UploadLayer(repository, configDigest, bytes.NewReader(config)); err != nil {
+		return fmt.Errorf("config upload failed: %v", err)
+	}
+	payload := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"%s","size":%d,"digest":"%s"},"layers":[{"mediaType":"%s","size":%d,"digest":"%s"}]}`,
+		schema2.MediaTypeManifest, schema2.MediaTypeConfig, len(config), configDigest, schema2.MediaTypeLayer, layerSize, layer))
+	if err := b.PutRawManifest(repository, tag, schema2.MediaTypeManifest, payload); err != nil {
+		return fmt.Errorf("raw manifest upload failed: %v", err)
+	}
+	mediaType, stored, err := b.RawManifest(repository, tag)
+	if err != nil {
+		return fmt.Errorf("raw manifest download failed: %v", err)
+	}
+	if mediaType != schema2.MediaTypeManifest || !bytes.Equal(stored, payload) {
+		return fmt.Errorf("downloaded raw manifest of media type %q differs from uploaded one", mediaType)
+	}
+	manifestDigest, err := b.ManifestDigest(repository, tag)
+	if err != nil {
+		return fmt.Errorf("raw manifest digest failed: %v", err)
+	}
+	if manifestDigest != digest.FromBytes(payload) {
+		return fmt.Errorf("raw manifest digest is %s, expected %s", manifestDigest, digest.FromBytes(payload))
+	}
+	if err := b.DeleteManifest(repository, manifestDigest); err != nil {
+		return fmt.Errorf("raw manifest delete failed: %v", err)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func referencesBlob(refs *References, d digest.Digest) bool {
+	for _, b := range refs.Blobs {
+		if b.Digest == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+)
+
+const (
+	//MediaTypeOCIManifest is OCI image manifest media type
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	//MediaTypeOCIIndex is OCI image index media type
+	MediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+)
+
+//ManifestTypes lists manifest media types backends are able to store, in order of preference
+var ManifestTypes = []string{
+	MediaTypeOCIIndex,
+	manifestlist.MediaTypeManifestList,
+	MediaTypeOCIManifest,
+	schema2.MediaTypeManifest,
+	manifestV1.MediaTypeSignedManifest,
+	manifestV1.MediaTypeManifest,
+}
+
+//References holds everything manifest points to. Index manifests reference child manifests, image manifests reference config and layer blobs
+type References struct {
+	Manifests []manifestlist.ManifestDescriptor
+	Blobs     []distribution.Descriptor
+}
+
+//ParseReferences reads manifests and blobs referenced by manifest payload of specified media type
+func ParseReferences(mediaType string, payload []byte) (*References, error) {
+	refs := &References{}
+	switch mediaType {
+	case MediaTypeOCIIndex, manifestlist.MediaTypeManifestList:
+		var index struct {
+			Manifests []manifestlist.ManifestDescriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(payload, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse image index: %v", err)
+		}
+		refs.Manifests = index.Manifests
+	case MediaTypeOCIManifest, schema2.MediaTypeManifest:
+		var m struct {
+			Config distribution.Descriptor   `json:"config"`
+			Layers []distribution.Descriptor `json:"layers"`
+		}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+		}
+		refs.Blobs = append(refs.Blobs, m.Config)
+		refs.Blobs = append(refs.Blobs, m.Layers...)
+	case manifestV1.MediaTypeSignedManifest, manifestV1.MediaTypeManifest:
+		var m manifestV1.SignedManifest
+		if err := m.UnmarshalJSON(payload); err != nil {
+			return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+		}
+		for _, l := range m.FSLayers {
+			refs.Blobs = append(refs.Blobs, distribution.Descriptor{MediaType: manifestV1.MediaTypeManifestLayer, Digest: l.BlobSum})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+	return refs, nil
+}
+
+//DetectMediaType guesses media type of manifest payload served without Content-Type
+func DetectMediaType(payload []byte) string {
+	var m struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+		Signatures    []json.RawMessage `json:"signatures"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return ""
+	}
+	switch {
+	case m.SchemaVersion == 1 && m.Signatures != nil:
+		return manifestV1.MediaTypeSignedManifest
+	case m.SchemaVersion == 1:
+		return manifestV1.MediaTypeManifest
+	case len(m.MediaType) > 0:
+		return m.MediaType
+	case m.Manifests != nil:
+		return MediaTypeOCIIndex
+	default:
+		return MediaTypeOCIManifest
+	}
+}
+
+//RawManifest downloads manifest the way registry stores it. Every supported media type is accepted, so registry does not convert schema2 manifests into schema1
+func (r *Registry) RawManifest(repository string, reference string) (string, []byte, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", r.URL, repository, reference)
+	r.Logf("registry.manifest.get url=%s repository=%s reference=%s", url, repository, reference)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, mediaType := range ManifestTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/json" || mediaType == "text/plain" {
+		mediaType = DetectMediaType(payload)
+	}
+	return mediaType, payload, nil
+}
+
+//PutRawManifest uploads manifest payload of media type. Heroku client is only able to put signed schema1 manifests
+func (r *Registry) PutRawManifest(repository string, reference string, mediaType string, payload []byte) error {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", r.URL, repository, reference)
+	r.Logf("registry.manifest.put url=%s repository=%s reference=%s", url, repository, reference)
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//ManifestDigest returns digest of manifest the way registry stores it. HEAD request accepts every supported media type,
+//otherwise registry converts schema2 manifests into schema1 and returns digest no manifest can be deleted by
+func (r *Registry) ManifestDigest(repository string, reference string) (digest.Digest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", r.URL, repository, reference)
+	r.Logf("registry.manifest.head url=%s repository=%s reference=%s", url, repository, reference)
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, mediaType := range ManifestTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return digest.ParseDigest(resp.Header.Get("Docker-Content-Digest"))
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//Registry is Backend of Docker Registry or registry storage directory connected by registry client
+type Registry struct {
+	*registry.Registry
+}
+
+//NewRegistry returns Backend of connected registry
+func NewRegistry(hub *registry.Registry) *Registry {
+	return &Registry{Registry: hub}
+}
+
+//Name returns registry URL
+func (r *Registry) Name() string {
+	return r.URL
+}
+
+//MountLayer asks registry to mount layer from another repository instead of uploading it. Returns false when registry refused the mount
+func (r *Registry) MountLayer(repository string, from string, layer digest.Digest) (bool, error) {
+	mountURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/?mount=%s&from=%s", r.URL, repository, url.QueryEscape(layer.String()), url.QueryEscape(from))
+	r.Logf("registry.layer.mount url=%s repository=%s digest=%s", mountURL, repository, layer)
+	resp, err := r.Client.Post(mountURL, "application/octet-stream", nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return true, nil
+	}
+	//Registry started regular upload session instead, cancel it
+	if location := resp.Header.Get("Location"); resp.StatusCode == http.StatusAccepted && len(location) > 0 {
+		if !strings.HasPrefix(location, "http") {
+			location = r.URL + location
+		}
+		req, err := http.NewRequest("DELETE", location, nil)
+		if err == nil {
+			if cancel, err := r.Client.Do(req); err == nil {
+				cancel.Body.Close()
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,26 @@
+package backend_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/registryfs"
+)
+
+func TestRegistryConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promoter-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hub, err := connection.Connect(registryfs.Prefix+dir, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Conformance(backend.NewRegistry(hub), "acme/app"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,7 @@
 #!/bin/bash
-#This file is used by Dockerfile
-export GOPATH=/tmp
-go build .
+#This file is used by Dockerfile, promoter is built statically so the image needs no other files
+export GO111MODULE=off
+CGO_ENABLED=0 go build .
 mkdir -p /opt/promoter
 cp ./promoter /opt/promoter/
-cp ./run.sh /opt/promoter/run.sh
 chmod 755 /opt/promoter/promoter
-chmod 755 /opt/promoter/run.sh
-
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/archive"
+)
+
+func init() {
+	var src registryFlags
+	var dest registryFlags
+	var excludeInventory string
+	var output string
+
+	var bundleCmd = &cobra.Command{
+		Use:   "bundle [registry/image/tag]...",
+		Short: "Pack images into bundle for air-gapped transfer",
+		Long: `Pack images into OCI layout tarball for air-gapped transfer.
+                Blobs recorded in the exclude inventory are left out of the bundle.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 1 || len(output) == 0 {
+				fmt.Println("Missing command arguments, usage: bundle [registry/image/tag]... -o bundle.tar")
+				os.Exit(1)
+			}
+			var srcRegistry string
+			images := make([]string, 0, len(args))
+			for _, arg := range args {
+				registry, image, tag, err := ImageNameAndRegistryAndTag(arg)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				if len(srcRegistry) > 0 && registry != srcRegistry {
+					fmt.Println("All bundled images must come from the same Registry")
+					os.Exit(1)
+				}
+				srcRegistry = registry
+				images = append(images, image+":"+tag)
+			}
+			if err := src.resolve(&srcRegistry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			b := &archive.Bundle{
+				SrcRegistry:      srcRegistry,
+				SrcImages:        images,
+				SrcUsername:      src.Username,
+				SrcPassword:      src.Password,
+				SrcInsecure:      src.Insecure,
+				ExcludeInventory: excludeInventory,
+				Output:           output,
+			}
+			if err := b.CreateBundle(); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	var unbundleCmd = &cobra.Command{
+		Use:   "unbundle [bundle.tar] [registry]",
+		Short: "Import bundle into Registry",
+		Long: `Push all bundled images into a Registry.
+                Tags are published only after all layers left out of the bundle are confirmed to exist on the Registry.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 2 {
+				fmt.Println("Missing command arguments, usage: unbundle [bundle.tar] [registry]")
+				os.Exit(1)
+			}
+			destRegistry := args[1]
+			if err := dest.resolve(&destRegistry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			u := &archive.Unbundle{
+				Bundle:       args[0],
+				DestRegistry: destRegistry,
+				DestUsername: dest.Username,
+				DestPassword: dest.Password,
+				DestInsecure: dest.Insecure,
+			}
+			if err := u.UnbundleImages(); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(bundleCmd)
+	RootCmd.AddCommand(unbundleCmd)
+
+	addRegistryFlags(bundleCmd.Flags(), &src, "src-", "Source")
+	bundleCmd.Flags().StringVar(&excludeInventory, "exclude-inventory", "", "Leave out blobs recorded in inventory file")
+	bundleCmd.Flags().StringVarP(&output, "output", "o", "", "Bundle file")
+	addRegistryFlags(unbundleCmd.Flags(), &dest, "dest-", "Destination")
+}
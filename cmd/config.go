@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/vbaksa/promoter/connection"
+	"gopkg.in/yaml.v2"
+)
+
+//EnvPrefix is prefix of environment variables setting flags, e.g. PROMOTER_DEST_USERNAME sets --dest-username
+const EnvPrefix = "PROMOTER"
+
+//configFile is path of config file given by --config flag
+var configFile string
+
+//profiles holds registry profiles of config file keyed by registry host e.g. registry.example.com:5000
+var profiles map[string]profile
+
+//profile holds connection settings of a single registry. Flags take precedence over profile
+type profile struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	//PasswordFile is read when Password is empty, e.g. Kubernetes service account token
+	PasswordFile string `yaml:"password-file"`
+	//PasswordEnv names environment variable holding password when Password and PasswordFile are empty
+	PasswordEnv string  `yaml:"password-env"`
+	CA          string  `yaml:"ca"`
+	Insecure    bool    `yaml:"insecure"`
+	HTTP        bool    `yaml:"http"`
+	RateLimit   float64 `yaml:"rate-limit"`
+	Proxy       string  `yaml:"proxy"`
+	NoProxy     string  `yaml:"no-proxy"`
+}
+
+//settings returns connection settings of the profile
+func (p profile) settings() connection.Settings {
+	return connection.Settings{CA: p.CA, RateLimit: p.RateLimit, Proxy: p.Proxy, NoProxy: p.NoProxy}
+}
+
+//password returns password of the profile from its credential source
+func (p profile) password() (string, error) {
+	switch {
+	case len(p.Password) > 0:
+		return p.Password, nil
+	case len(p.PasswordFile) > 0:
+		data, err := ioutil.ReadFile(p.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case len(p.PasswordEnv) > 0:
+		return os.Getenv(p.PasswordEnv), nil
+	}
+	return "", nil
+}
+
+//config holds keys of config file. Nested sections are maps, e.g. command sections
+type config map[string]interface{}
+
+//loadConfig sets flags of cmd which were not given on command line from environment variables and config file.
+//Command section of config file takes precedence over top level keys, e.g. tags.overwrite over overwrite.
+//Config file is --config, or ~/.promoter.yaml when it exists. JSON config file is read as well, as JSON is valid YAML
+func loadConfig(cmd *cobra.Command) error {
+	path := configFile
+	if len(path) == 0 {
+		path = defaultConfigFile()
+	}
+	c := config{}
+	var registries struct {
+		Registries map[string]profile `yaml:"registries"`
+	}
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read config file %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("cannot read config file %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, &registries); err != nil {
+			return errors.New("invalid registry profiles: " + err.Error())
+		}
+	}
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		key := cmd.Name() + "." + f.Name
+		value, ok := c.lookup(cmd.Name(), f.Name)
+		if !ok {
+			key = f.Name
+			value, ok = c.lookup(f.Name)
+		}
+		if !ok {
+			return
+		}
+		if setErr := setFlag(f, value); setErr != nil {
+			err = fmt.Errorf("invalid value of %s: %v", key, setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	profiles = make(map[string]profile)
+	for host, p := range registries.Registries {
+		profiles[host] = p
+		replaceRegistryName(&host)
+		connection.Configure(host, p.settings())
+	}
+	return nil
+}
+
+//lookup returns value of key given by path of config sections, e.g. "tags", "overwrite". Environment variable of the key
+//e.g. PROMOTER_TAGS_OVERWRITE takes precedence over config file
+func (c config) lookup(path ...string) (interface{}, bool) {
+	env := EnvPrefix + "_" + strings.ToUpper(strings.Replace(strings.Join(path, "_"), "-", "_", -1))
+	if v := os.Getenv(env); len(v) > 0 {
+		return v, true
+	}
+	var section interface{} = map[string]interface{}(c)
+	for _, name := range path {
+		switch s := section.(type) {
+		case map[string]interface{}:
+			section = s[name]
+		case map[interface{}]interface{}:
+			section = s[name]
+		default:
+			return nil, false
+		}
+		if section == nil {
+			return nil, false
+		}
+	}
+	switch section.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		//Section is not a value, e.g. command section named like a flag
+		return nil, false
+	}
+	return section, true
+}
+
+//defaultConfigFile returns ~/.promoter.yaml when it exists
+func defaultConfigFile() string {
+	home := os.Getenv("HOME")
+	if len(home) == 0 {
+		return ""
+	}
+	path := filepath.Join(home, ".promoter.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+//setFlag sets flag to config value. Every item of a list is added to repeatable flags
+func setFlag(f *pflag.Flag, value interface{}) error {
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if err := f.Value.Set(fmt.Sprint(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return f.Value.Set(fmt.Sprint(value))
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/doctor"
+)
+
+func init() {
+	var src registryFlags
+	var dest registryFlags
+	var checkMount bool
+
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor [registry/image[:tag]] [registry/image[:tag]]",
+		Short: "Check registries before promotion",
+		Long: `Check DNS, TLS, API version, authentication, pull and push permissions of both registries before promotion.
+                Push permission is checked by opening and cancelling an upload session, so nothing is written to destination.
+                Cross repository mount is checked only with --check-mount: it mounts a source layer into destination
+                repository when both are on the same registry, which leaves the layer there and may create the repository.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 2 {
+				fmt.Println("Missing command arguments, usage: doctor [registry/image[:tag]] [registry/image[:tag]]")
+				os.Exit(1)
+			}
+			srcTarget, err := doctorTarget(args[0], &src)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destTarget, err := doctorTarget(args[1], &dest)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			results := doctor.Check(context.Background(), srcTarget, destTarget, checkMount)
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "REGISTRY\tCHECK\tRESULT\tDETAIL")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Registry, r.Check, r.Status, r.Detail)
+			}
+			w.Flush()
+			if doctor.Failed(results) {
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&checkMount, "check-mount", false, "Check cross repository mount by mounting a source layer into destination repository. Writes to destination")
+	addRegistryFlags(doctorCmd.Flags(), &src, "src-", "Source")
+	addRegistryFlags(doctorCmd.Flags(), &dest, "dest-", "Destination")
+}
+
+//doctorTarget returns checked repository of image reference. Tag is optional, checks of tag are skipped without it
+func doctorTarget(ref string, r *registryFlags) (doctor.Target, error) {
+	registry, image, tag, err := ImageNameAndRegistryAndTag(ref)
+	if err != nil {
+		return doctor.Target{}, err
+	}
+	if !hasTag(ref) {
+		tag = ""
+	}
+	if err := r.resolve(&registry); err != nil {
+		return doctor.Target{}, err
+	}
+	return doctor.Target{
+		URL:        registry,
+		Repository: image,
+		Tag:        tag,
+		Username:   r.Username,
+		Password:   r.Password,
+		Insecure:   r.Insecure,
+	}, nil
+}
+
+//hasTag reports whether image reference names a tag
+func hasTag(ref string) bool {
+	i := strings.LastIndex(ref, ":")
+	return i > strings.LastIndex(ref, "/")
+}
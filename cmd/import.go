@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/archive"
+)
+
+func init() {
+	var dest registryFlags
+
+	var importCmd = &cobra.Command{
+		Use:   "import [transport:path[:reference]] [registry/image/tag]",
+		Short: "Import image from local archive",
+		Long: `Import image from OCI layout, OCI archive or docker save archive into a Registry.
+                Supported sources: oci:/path/to/layout[:reference], oci-archive:/path/to/archive.tar[:reference], docker-archive:/path/to/archive.tar[:image:tag]`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 2 {
+				fmt.Println("Missing command arguments, usage: import [transport:path[:reference]] [registry/image/tag]")
+				os.Exit(1)
+			}
+			source, err := archive.ParseSource(args[0])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destRegistry, destImage, destImageTag, err := ImageNameAndRegistryAndTag(args[1])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if err := dest.resolve(&destRegistry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			im := &archive.Import{
+				Source:       source,
+				DestRegistry: destRegistry,
+				DestImage:    destImage,
+				DestImageTag: destImageTag,
+				DestUsername: dest.Username,
+				DestPassword: dest.Password,
+				DestInsecure: dest.Insecure,
+			}
+			if err := im.ImportImage(); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(importCmd)
+
+	addRegistryFlags(importCmd.Flags(), &dest, "dest-", "Destination")
+}
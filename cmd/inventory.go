@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/inventory"
+	"github.com/vbaksa/promoter/progress"
+)
+
+func init() {
+	var reg registryFlags
+	var repositories []string
+	var output string
+
+	var inventoryCmd = &cobra.Command{
+		Use:   "inventory [registry]",
+		Short: "Record blobs present on a Registry",
+		Long: `Record blob digests referenced by all tags of specified repositories.
+                Inventory is used by bundle command to leave out blobs the Registry already holds.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Missing command arguments, usage: inventory [registry]")
+				os.Exit(1)
+			}
+			registry := args[0]
+			if err := reg.resolve(&registry); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+
+			c := &inventory.Collect{
+				Registry:     registry,
+				Username:     reg.Username,
+				Password:     reg.Password,
+				Insecure:     reg.Insecure,
+				Repositories: repositories,
+				Output:       output,
+				//Progress goes to stderr, so that inventory can be redirected from stdout
+				Progress: progress.Console{Out: os.Stderr},
+			}
+			if err := c.CollectInventory(); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(inventoryCmd)
+
+	addRegistryFlags(inventoryCmd.Flags(), &reg, "", "")
+	inventoryCmd.Flags().StringSliceVar(&repositories, "repos", nil, "Repositories to inventory e.g. library/centos,library/ubuntu. Registry catalog is used when omitted")
+	inventoryCmd.Flags().StringVarP(&output, "output", "o", "", "Write inventory to file instead of stdout")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/vbaksa/promoter/connection"
+)
+
+//registryFlags holds connection options of a single registry. Options left empty are taken from registry profile of config file
+type registryFlags struct {
+	Username string
+	Password string
+	Insecure bool
+	HTTP     bool
+	Proxy    string
+}
+
+//addRegistryFlags registers username, password, http, insecure and proxy flags of registry. Prefix is e.g. "src-" and role "Source",
+//commands working with a single registry use empty prefix and "" role
+func addRegistryFlags(flags *pflag.FlagSet, r *registryFlags, prefix string, role string) {
+	name := "Registry"
+	username := "Registry username"
+	password := "Registry password"
+	if len(role) > 0 {
+		name = role + " Registry"
+		username = role + " username"
+		password = role + " password"
+	}
+	flags.StringVar(&r.Username, prefix+"username", "", username)
+	flags.StringVar(&r.Password, prefix+"password", "", password)
+	flags.BoolVar(&r.HTTP, prefix+"http", false, "Use http when connecting to "+name)
+	flags.BoolVar(&r.Insecure, prefix+"insecure", false, "Accept all certificates when connecting to "+name)
+	flags.StringVar(&r.Proxy, prefix+"proxy", "", "Proxy URL of "+name+" e.g. http://proxy:3128 or socks5://bastion:1080, direct disables proxy. Default is HTTP_PROXY and HTTPS_PROXY")
+}
+
+//resolve fills options missing in flags from profile of registry host, configures proxy of the host and adds protocol to registry address
+func (r *registryFlags) resolve(registry *string) error {
+	p, ok := profiles[*registry]
+	replaceRegistryName(registry)
+	if !ok {
+		p = profiles[*registry]
+	}
+	if len(r.Username) == 0 {
+		r.Username = p.Username
+	}
+	if len(r.Password) == 0 {
+		password, err := p.password()
+		if err != nil {
+			return err
+		}
+		r.Password = password
+	}
+	r.Insecure = r.Insecure || p.Insecure
+	r.HTTP = r.HTTP || p.HTTP
+	if len(r.Proxy) > 0 && r.Proxy != p.Proxy && !isLocalStorage(*registry) {
+		//Proxy flag overrides proxy of profile for all connections to the host
+		p.Proxy = r.Proxy
+		connection.Configure(*registry, p.settings())
+	}
+	addRegistryProtocol(registry, !r.HTTP)
+	return nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/report"
+	"github.com/vbaksa/promoter/tags"
+)
+
+func init() {
+	var reg registryFlags
+	var keepLast int
+	var order string
+	var keepRegexp string
+	var deleteOlderThan string
+	var dryRun bool
+	var output string
+	var reportFile string
+
+	var retainCmd = &cobra.Command{
+		Use:   "retain [registry/image]",
+		Short: "Delete image tags not kept by retention policy",
+		Long: `Delete old image tags of a repository. Tag is deleted when it is not kept by any retention policy.
+                Tags pointing to a manifest shared with a kept tag are not deleted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 1 {
+				fmt.Println("Missing command arguments, usage: retain [registry/image]")
+				os.Exit(1)
+			}
+			registry, image, err := ImageNameAndRegistry(args[0])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if err := reg.resolve(&registry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if !report.ValidFormat(output) {
+				fmt.Println("Invalid output format " + output + ", expected one of: text, json")
+				os.Exit(1)
+			}
+			out := &report.Output{Format: output, File: reportFile}
+			prog := progress.Console{Out: out.Messages()}
+
+			r := &tags.Retention{
+				Registry:        registry,
+				Image:           image,
+				Username:        reg.Username,
+				Password:        reg.Password,
+				Insecure:        reg.Insecure,
+				KeepLast:        keepLast,
+				Order:           order,
+				KeepRegexp:      keepRegexp,
+				DeleteOlderThan: deleteOlderThan,
+				DryRun:          dryRun,
+				Progress:        prog,
+			}
+			result, err := r.RetainTags()
+			writeReport(out, result, err, prog)
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(retainCmd)
+
+	addRegistryFlags(retainCmd.Flags(), &reg, "", "")
+	retainCmd.Flags().IntVar(&keepLast, "keep-last", 0, "Keep N newest images, tags pointing to the same image are counted once")
+	retainCmd.Flags().StringVar(&order, "order", tags.OrderCreated, "Order of tags kept by keep-last: created or semver. Tags which are not semantic versions are kept by semver order unless delete-older-than applies")
+	retainCmd.Flags().StringVar(&keepRegexp, "keep-regexp", "", "Always keep tags matching specified regexp")
+	retainCmd.Flags().StringVar(&deleteOlderThan, "delete-older-than", "", "Delete only images created before date or earlier than duration ago e.g. 2017-03-01, 720h, 30d")
+	retainCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report tags which would be deleted without deleting them")
+	retainCmd.Flags().StringVar(&output, "output", report.FormatText, "Output format: text or json. JSON report is printed to stdout and progress to stderr")
+	retainCmd.Flags().StringVar(&reportFile, "report", "", "Write JSON report into file")
+}
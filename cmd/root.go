@@ -1,18 +1,27 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"regexp"
+	"io"
 	"strings"
 
 	"os"
 
+	"github.com/vbaksa/promoter/archive"
+	"github.com/vbaksa/promoter/connection"
 	"github.com/vbaksa/promoter/image"
-	"github.com/vbaksa/promoter/tags"
+	"github.com/vbaksa/promoter/logging"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/promote"
+	"github.com/vbaksa/promoter/prune"
+	"github.com/vbaksa/promoter/registryfs"
+	"github.com/vbaksa/promoter/report"
 
 	"errors"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -25,23 +34,115 @@ var RootCmd = &cobra.Command{
 	Short: "Promotes Docker images",
 	Long: `Promotes Docker images from one Registry into another.
                 Optimizes network traffic by inspecting existing image data.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+		if debug {
+			logLevel = "debug"
+		}
+		transport.DisableHTTP2 = !http2
+		connection.SetOptions(transport)
+		return logging.Setup(logLevel, logFormat)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	},
 }
 
+//Logging options shared by all commands
+var (
+	logLevel  string
+	logFormat string
+	debug     bool
+)
+
+//Transport options shared by all commands
+var (
+	transport = connection.DefaultOptions
+	http2     bool
+)
+
+//promotionFlags holds options shared by push and tags commands
+type promotionFlags struct {
+	src            registryFlags
+	dest           registryFlags
+	overwrite      string
+	force          bool
+	digestMap      string
+	output         string
+	reportFile     string
+	progressFormat string
+	progressFD     int
+}
+
+//register adds options shared by push and tags commands to flags
+func (o *promotionFlags) register(flags *pflag.FlagSet) {
+	addRegistryFlags(flags, &o.src, "src-", "Source")
+	addRegistryFlags(flags, &o.dest, "dest-", "Destination")
+	flags.StringVar(&o.overwrite, "overwrite", image.OverwriteAlways, "Existing destination tag handling: never, if-different or always. Tags holding the same image are skipped under every policy: "+
+		"equal manifest digests cost two HEAD requests, tags promoted under another name cost one more source and destination manifest GET unless remembered by --digest-map")
+	flags.BoolVar(&o.force, "force", false, "Replace destination tags holding different image when overwrite is if-different")
+	flags.StringVar(&o.digestMap, "digest-map", "", "File remembering source and destination manifest digests of promoted tags, so unchanged tags promoted under another name are skipped by HEAD requests only")
+	flags.StringVar(&o.output, "output", report.FormatText, "Output format: text or json. JSON report is printed to stdout and progress to stderr")
+	flags.StringVar(&o.reportFile, "report", "", "Write JSON report into file")
+	flags.StringVar(&o.progressFormat, "progress", progress.FormatText, "Progress format: text or jsonl. JSON lines events are written into --progress-fd")
+	flags.IntVar(&o.progressFD, "progress-fd", 2, "File descriptor receiving jsonl progress events, 2 is stderr")
+}
+
+//prepare resolves both registries and validates shared options
+func (o *promotionFlags) prepare(srcRegistry *string, destRegistry *string) error {
+	if err := o.src.resolve(srcRegistry); err != nil {
+		return err
+	}
+	if err := o.dest.resolve(destRegistry); err != nil {
+		return err
+	}
+	if !image.ValidOverwrite(o.overwrite) {
+		return errors.New("Invalid overwrite policy " + o.overwrite + ", expected one of: never, if-different, always")
+	}
+	if !report.ValidFormat(o.output) {
+		return errors.New("Invalid output format " + o.output + ", expected one of: text, json")
+	}
+	return nil
+}
+
+//source returns connection options of Source Registry
+func (o *promotionFlags) source(url string) promote.Registry {
+	return promote.Registry{URL: url, Username: o.src.Username, Password: o.src.Password, Insecure: o.src.Insecure}
+}
+
+//destination returns connection options of Destination Registry
+func (o *promotionFlags) destination(url string) promote.Registry {
+	return promote.Registry{URL: url, Username: o.dest.Username, Password: o.dest.Password, Insecure: o.dest.Insecure}
+}
+
 func init() {
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file, ~/.promoter.yaml is read when it exists. Flags can also be set by PROMOTER_ environment variables e.g. PROMOTER_DEST_PASSWORD")
+	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warning or error. Debug level logs sanitized summaries of registry requests")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "Log format: text or json. Logs are written to stderr")
+	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Debug, same as --log-level=debug")
+	RootCmd.PersistentFlags().DurationVar(&transport.DialTimeout, "dial-timeout", transport.DialTimeout, "Timeout of establishing connection to Registry, 0 disables")
+	RootCmd.PersistentFlags().DurationVar(&transport.TLSHandshakeTimeout, "tls-timeout", transport.TLSHandshakeTimeout, "Timeout of TLS handshake, 0 disables")
+	RootCmd.PersistentFlags().DurationVar(&transport.ResponseHeaderTimeout, "response-header-timeout", transport.ResponseHeaderTimeout, "Timeout of waiting for response headers after request was sent, 0 disables")
+	RootCmd.PersistentFlags().DurationVar(&transport.IdleConnTimeout, "idle-timeout", transport.IdleConnTimeout, "Close pooled connections idle for longer, 0 disables")
+	RootCmd.PersistentFlags().IntVar(&transport.MaxIdleConnsPerHost, "max-idle-conns-per-host", transport.MaxIdleConnsPerHost, "Idle connections kept in pool of each Registry host")
+	RootCmd.PersistentFlags().BoolVar(&http2, "http2", true, "Use HTTP/2 when Registry supports it")
+
 	//optional parameters
-	var srcUsername string
-	var srcPassword string
-	var destUsername string
-	var destPassword string
-	var debug bool
-	var srcInsecure bool
-	var destInsecure bool
-	var srcHTTP bool
-	var destHTTP bool
+	var opts promotionFlags
 	var tagRegexp string
+	var tagExcludeRegexp string
+	var tagSemver string
+	var latest int
+	var tagsFrom string
+	var newerThan string
+	var olderThan string
+	var labels []string
+	var destTagTemplate string
+	var pruneTags bool
+	var maxPrune int
+	var dryRun bool
 
 	var versionCmd = &cobra.Command{
 		Use:   "version",
@@ -71,35 +172,33 @@ func init() {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			replaceRegistryName(&srcRegistry)
-			replaceRegistryName(&destRegistry)
-			if srcHTTP {
-				addRegistryProtocol(&srcRegistry, false)
-			} else {
-				addRegistryProtocol(&srcRegistry, true)
-			}
-			if destHTTP {
-				addRegistryProtocol(&destRegistry, false)
-			} else {
-				addRegistryProtocol(&destRegistry, true)
+			if err := opts.prepare(&srcRegistry, &destRegistry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
 
-			prom := &image.Promote{
-				SrcRegistry:  srcRegistry,
-				SrcImage:     srcImage,
-				SrcImageTag:  srcImageTag,
-				SrcUsername:  srcUsername,
-				SrcPassword:  srcPassword,
-				SrcInsecure:  srcInsecure,
-				DestRegistry: destRegistry,
-				DestImage:    destImage,
-				DestImageTag: destImageTag,
-				DestUsername: destUsername,
-				DestPassword: destPassword,
-				DestInsecure: destInsecure,
-				Debug:        debug,
+			out := &report.Output{Format: opts.output, File: opts.reportFile}
+			prog, err := newProgress(opts.progressFormat, opts.progressFD, out.Messages())
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
-			prom.PromoteImage()
+			prog.Printf("Preparing Image Push\n")
+			r, err := promote.Image(context.Background(), promote.ImageOptions{
+				Source:           opts.source(srcRegistry),
+				SourceImage:      srcImage,
+				SourceTag:        srcImageTag,
+				Destination:      opts.destination(destRegistry),
+				DestinationImage: destImage,
+				DestinationTag:   destImageTag,
+				Overwrite:        opts.overwrite,
+				Force:            opts.force,
+				DigestMap:        opts.digestMap,
+				Progress:         prog,
+			})
+			writeReport(out, r, err, prog)
+			prog.Printf("Push Complete\n")
+			os.Exit(0)
 
 		},
 	}
@@ -124,40 +223,47 @@ func init() {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			replaceRegistryName(&srcRegistry)
-			replaceRegistryName(&destRegistry)
-			if srcHTTP {
-				addRegistryProtocol(&srcRegistry, false)
-			} else {
-				addRegistryProtocol(&srcRegistry, true)
+			if err := opts.prepare(&srcRegistry, &destRegistry); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
-			if destHTTP {
-				addRegistryProtocol(&destRegistry, false)
-			} else {
-				addRegistryProtocol(&destRegistry, true)
+			tagsOpts := promote.TagsOptions{
+				Source:           opts.source(srcRegistry),
+				SourceImage:      srcImage,
+				Destination:      opts.destination(destRegistry),
+				DestinationImage: destImage,
+				TagRegexp:        tagRegexp,
+				TagExcludeRegexp: tagExcludeRegexp,
+				TagSemver:        tagSemver,
+				Latest:           latest,
+				TagsFrom:         tagsFrom,
+				NewerThan:        newerThan,
+				OlderThan:        olderThan,
+				Labels:           labels,
+				Overwrite:        opts.overwrite,
+				Force:            opts.force,
+				DigestMap:        opts.digestMap,
+				DestTagTemplate:  destTagTemplate,
+				Prune:            pruneTags,
+				MaxPrune:         maxPrune,
+				DryRun:           dryRun,
 			}
-			if len(tagRegexp) > 0 {
-				_, err = regexp.Compile(tagRegexp)
-				if err != nil {
-					fmt.Printf("Image Tag Regexp does not compile. Error: %q \n", err)
-				}
+			if err := tagsOpts.Validate(); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
 
-			prom := &tags.TagPush{
-				SrcRegistry:  srcRegistry,
-				SrcImage:     srcImage,
-				SrcUsername:  srcUsername,
-				SrcPassword:  srcPassword,
-				SrcInsecure:  srcInsecure,
-				DestRegistry: destRegistry,
-				DestImage:    destImage,
-				DestUsername: destUsername,
-				DestPassword: destPassword,
-				DestInsecure: destInsecure,
-				TagRegexp:    tagRegexp,
-				Debug:        debug,
+			out := &report.Output{Format: opts.output, File: opts.reportFile}
+			prog, err := newProgress(opts.progressFormat, opts.progressFD, out.Messages())
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
-			prom.PushTags()
+			tagsOpts.Progress = prog
+			prog.Printf("Preparing tags push\n")
+			r, err := promote.Tags(context.Background(), tagsOpts)
+			writeReport(out, r, err, prog)
+			os.Exit(0)
 
 		},
 	}
@@ -166,31 +272,59 @@ func init() {
 	RootCmd.AddCommand(promoteCmd)
 	RootCmd.AddCommand(tagsCmd)
 
-	promoteCmd.Flags().StringVar(&srcUsername, "src-username", "", "Source username")
-	promoteCmd.Flags().StringVar(&srcPassword, "src-password", "", "Source password")
-	promoteCmd.Flags().StringVar(&destUsername, "dest-username", "", "Destination username")
-	promoteCmd.Flags().StringVar(&destPassword, "dest-password", "", "Destination password")
-	promoteCmd.Flags().BoolVar(&srcHTTP, "src-http", false, "Use http when connecting to Source Registry")
-	promoteCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Source Registry")
-	promoteCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
-	promoteCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registry")
-	promoteCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registry")
-	tagsCmd.Flags().StringVar(&srcUsername, "src-username", "", "Source username")
-	tagsCmd.Flags().StringVar(&srcPassword, "src-password", "", "Source password")
-	tagsCmd.Flags().StringVar(&destUsername, "dest-username", "", "Destination username")
-	tagsCmd.Flags().StringVar(&destPassword, "dest-password", "", "Destination password")
-	tagsCmd.Flags().BoolVar(&srcHTTP, "src-http", false, "Use http when connecting to Source Registry")
-	tagsCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Source Registry")
-
-	tagsCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
-
-	tagsCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registry")
-	tagsCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registry")
+	opts.register(promoteCmd.Flags())
+	opts.register(tagsCmd.Flags())
 	tagsCmd.Flags().StringVar(&tagRegexp, "tag-regexp", "", "Filter image tags by specified regexp")
+	tagsCmd.Flags().StringVar(&tagExcludeRegexp, "tag-exclude-regexp", "", "Skip image tags matching specified regexp")
+	tagsCmd.Flags().StringVar(&tagSemver, "tag-semver", "", "Filter image tags by semantic version constraint e.g. '>=1.4.0 <2.0.0'")
+	tagsCmd.Flags().IntVar(&latest, "latest", 0, "Push only N highest semantic versions")
+	tagsCmd.Flags().StringVar(&tagsFrom, "tags-from", "", "Push only tags listed in file, one tag per line")
+	tagsCmd.Flags().StringVar(&newerThan, "newer-than", "", "Push only images created after date or within duration e.g. 2017-03-01, 720h, 30d")
+	tagsCmd.Flags().StringVar(&olderThan, "older-than", "", "Push only images created before date or earlier than duration ago e.g. 2017-03-01, 720h, 30d")
+	tagsCmd.Flags().StringVar(&destTagTemplate, "dest-tag-template", "", "Go template of destination tag e.g. '{{.Tag}}-prod'. Capture groups of tag regexp are available as {{index .Groups 1}} and {{.Named.name}}")
+	tagsCmd.Flags().StringArrayVar(&labels, "label", nil, "Push only images having label key=value or key. Can be repeated")
+	tagsCmd.Flags().BoolVar(&pruneTags, "prune", false, "Delete destination tags matching tag selectors which no longer exist on Source Registry")
+	tagsCmd.Flags().IntVar(&maxPrune, "max-prune", prune.DefaultMaxPrune, "Refuse pruning when more tags would be deleted, -1 disables the limit")
+	tagsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report tags which would be pushed and pruned without changing Destination Registry")
+}
+
+//writeReport writes report of finished promotion and exits on promotion failure
+func writeReport(out *report.Output, r *report.Report, err error, p progress.Progress) {
+	if r != nil {
+		if reportErr := out.Write(r); reportErr != nil {
+			p.Printf("%s\n", reportErr.Error())
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		p.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+//newProgress returns console progress printing to out, or progress writing JSON lines events into file descriptor
+func newProgress(format string, fd int, out io.Writer) (progress.Progress, error) {
+	switch format {
+	case "", progress.FormatText:
+		return progress.Console{Out: out}, nil
+	case progress.FormatJSONL:
+		f := os.NewFile(uintptr(fd), "progress")
+		if f == nil {
+			return nil, fmt.Errorf("invalid progress file descriptor %d", fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("invalid progress file descriptor %d: %v", fd, err)
+		}
+		return progress.NewJSONL(f), nil
+	}
+	return nil, errors.New("Invalid progress format " + format + ", expected one of: text, jsonl")
 }
 
 //ImageNameAndRegistry returns registry, image from provided fqdn
 func ImageNameAndRegistry(url string) (registry string, image string, err error) {
+	if isLocalStorage(url) {
+		return localStorageNameAndRegistry(url)
+	}
 	s := strings.Split(url, "/")
 	if len(s) < 3 {
 		return "", "", errors.New("invalid image reference. Image format should be following: [registry/repository/image] e.g. myregistry/repository/centos")
@@ -203,6 +337,17 @@ func ImageNameAndRegistry(url string) (registry string, image string, err error)
 
 //ImageNameAndRegistryAndTag returns registry, image and tag from provided fqdn
 func ImageNameAndRegistryAndTag(src string) (registry string, image string, tag string, err error) {
+	if isLocalStorage(src) {
+		registry, image, err = localStorageNameAndRegistry(src)
+		if err != nil {
+			return "", "", "", err
+		}
+		//Tag separator is the last colon after the last slash
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			return registry, image[:i], image[i+1:], nil
+		}
+		return registry, image, "latest", nil
+	}
 	s := strings.Split(src, "/")
 	if len(s) < 3 {
 		return "", "", "", errors.New("invalid image reference. Image format should be following: [registry/repository/image] e.g. hub.docker.io/library/centos")
@@ -223,8 +368,35 @@ func ImageNameAndRegistryAndTag(src string) (registry string, image string, tag
 	return registry, image, tag, nil
 }
 
+//localStoragePrefixes mark registry storage directory and OCI image layouts used instead of a Registry
+var localStoragePrefixes = []string{registryfs.Prefix, archive.LayoutPrefix, archive.ArchivePrefix}
+
+//isLocalStorage reports whether registry is registry storage directory or OCI image layout
+func isLocalStorage(registry string) bool {
+	return registryfs.IsRegistryFS(registry) || archive.IsLayout(registry)
+}
+
+//localStorageNameAndRegistry returns storage and image from registry-fs:/path:repository/image, oci:/path:repository/image
+//or oci-archive:/path:repository/image reference
+func localStorageNameAndRegistry(url string) (registry string, image string, err error) {
+	for _, prefix := range localStoragePrefixes {
+		if !strings.HasPrefix(url, prefix) {
+			continue
+		}
+		s := strings.SplitN(strings.TrimPrefix(url, prefix), ":", 2)
+		if len(s) < 2 || len(s[0]) == 0 || len(strings.Split(s[1], "/")) < 2 {
+			break
+		}
+		return prefix + s[0], s[1], nil
+	}
+	return "", "", errors.New("invalid local storage reference. Format should be following: [registry-fs:/path:repository/image], [oci:/path:repository/image] or [oci-archive:/path:repository/image] e.g. registry-fs:/var/lib/registry:library/centos")
+}
+
 //Adds HTTP or HTTPS suffix if it's missing
 func addRegistryProtocol(registry *string, secure bool) {
+	if isLocalStorage(*registry) {
+		return
+	}
 	if !strings.HasPrefix(*registry, "http") || !strings.HasPrefix(*registry, "https") {
 		if secure {
 			*registry = "https://" + *registry
@@ -236,7 +408,7 @@ func addRegistryProtocol(registry *string, secure bool) {
 
 //Replaces some hardcoded registry names
 func replaceRegistryName(registry *string) {
-	if strings.Contains(*registry, "docker.io") {
+	if !isLocalStorage(*registry) && strings.Contains(*registry, "docker.io") {
 		//*registry = "index.docker.io"
 		*registry = "registry-1.docker.io"
 
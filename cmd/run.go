@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/logging"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/promote"
+	"github.com/vbaksa/promoter/report"
+)
+
+//ServiceAccountToken is path of Kubernetes service account token mounted into pods
+const ServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+//tokenUsername is username sent with service account token, OpenShift registries accept any username
+const tokenUsername = "builder"
+
+//imagePair is source and destination image of run command
+type imagePair struct {
+	Source      string
+	Destination string
+}
+
+func init() {
+	var opts promotionFlags
+	var images []string
+	var tokenFile string
+
+	var runCmd = &cobra.Command{
+		Use:   "run",
+		Short: "Push images configured by environment",
+		Long: `Push images configured by environment variables or config file. Intended as container entrypoint.
+                Registry password defaults to Kubernetes service account token when running in a pod.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			pairs, err := imagePairs(append(images, args...))
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if len(pairs) == 0 {
+				fmt.Println("No images to push, set PROMOTER_IMAGE=source=destination or SRC_IMAGE and DEST_IMAGE")
+				os.Exit(1)
+			}
+			legacyRegistryEnv(&opts.src, "SRC_")
+			legacyRegistryEnv(&opts.dest, "DEST_")
+			token, err := serviceAccountToken(tokenFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			out := &report.Output{Format: opts.output, File: opts.reportFile}
+			prog, err := newProgress(opts.progressFormat, opts.progressFD, out.Messages())
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			r := report.New()
+			failed := 0
+			for _, pair := range pairs {
+				//Every image resolves its own registry profiles
+				o := opts
+				tag, bytes, err := runImage(&o, pair, token, prog)
+				if tag != nil {
+					r.Add(tag)
+				}
+				r.BytesTransferred += bytes
+				if err != nil {
+					failed++
+					prog.Printf("%s\n", err.Error())
+				}
+			}
+			r.Finish()
+			if err := out.Write(r); err != nil {
+				prog.Printf("%s\n", err.Error())
+				os.Exit(1)
+			}
+			if failed > 0 {
+				prog.Printf("%d of %d images failed to push\n", failed, len(pairs))
+				os.Exit(1)
+			}
+			prog.Printf("Push Complete\n")
+			os.Exit(0)
+
+		},
+	}
+	RootCmd.AddCommand(runCmd)
+	opts.register(runCmd.Flags())
+	runCmd.Flags().StringArrayVar(&images, "image", nil, "Image to push as source=destination e.g. registry-a/repo/app:1.0=registry-b/repo/app:1.0. Can be repeated, several images can be separated by whitespace")
+	runCmd.Flags().StringVar(&tokenFile, "service-account-token", ServiceAccountToken, "Token used as password of registries without password when the file exists, empty disables")
+}
+
+//runImage pushes single image of run command. Returned tag is nil when image reference is invalid
+func runImage(o *promotionFlags, pair imagePair, token string, prog progress.Progress) (*report.Tag, int64, error) {
+	srcRegistry, srcImage, srcImageTag, err := ImageNameAndRegistryAndTag(pair.Source)
+	if err != nil {
+		return nil, 0, err
+	}
+	destRegistry, destImage, destImageTag, err := ImageNameAndRegistryAndTag(pair.Destination)
+	if err != nil {
+		return nil, 0, err
+	}
+	failed := &report.Tag{
+		Source:      report.Reference(srcRegistry, srcImage, srcImageTag),
+		Destination: report.Reference(destRegistry, destImage, destImageTag),
+	}
+	if err := o.prepare(&srcRegistry, &destRegistry); err != nil {
+		failed.Fail(err)
+		return failed, 0, err
+	}
+	useToken(&o.src, srcRegistry, token)
+	useToken(&o.dest, destRegistry, token)
+	prog.Printf("Preparing Image Push %s -> %s\n", pair.Source, pair.Destination)
+	r, err := promote.Image(context.Background(), promote.ImageOptions{
+		Source:           o.source(srcRegistry),
+		SourceImage:      srcImage,
+		SourceTag:        srcImageTag,
+		Destination:      o.destination(destRegistry),
+		DestinationImage: destImage,
+		DestinationTag:   destImageTag,
+		Overwrite:        o.overwrite,
+		Force:            o.force,
+		DigestMap:        o.digestMap,
+		Progress:         prog,
+	})
+	if r == nil || len(r.Tags) == 0 {
+		if err != nil {
+			failed.Fail(err)
+		}
+		return failed, 0, err
+	}
+	return r.Tags[0], r.BytesTransferred, err
+}
+
+//imagePairs parses source=destination image pairs. Values may hold several whitespace separated pairs, so a single
+//environment variable can list all images. SRC_IMAGE and DEST_IMAGE environment variables add one more pair
+func imagePairs(values []string) ([]imagePair, error) {
+	var pairs []imagePair
+	for _, value := range values {
+		for _, field := range strings.Fields(value) {
+			s := strings.SplitN(field, "=", 2)
+			if len(s) < 2 || len(s[0]) == 0 || len(s[1]) == 0 {
+				return nil, fmt.Errorf("invalid image %q, expected source=destination", field)
+			}
+			pairs = append(pairs, imagePair{Source: s[0], Destination: s[1]})
+		}
+	}
+	src, dest := os.Getenv("SRC_IMAGE"), os.Getenv("DEST_IMAGE")
+	if len(src) > 0 || len(dest) > 0 {
+		if len(src) == 0 || len(dest) == 0 {
+			return nil, fmt.Errorf("SRC_IMAGE and DEST_IMAGE must be set together")
+		}
+		pairs = append(pairs, imagePair{Source: src, Destination: dest})
+	}
+	return pairs, nil
+}
+
+//legacyRegistryEnv fills credentials missing in flags from SRC_ and DEST_ environment variables read by former run.sh
+func legacyRegistryEnv(r *registryFlags, prefix string) {
+	if len(r.Username) == 0 {
+		r.Username = os.Getenv(prefix + "USERNAME")
+	}
+	if len(r.Password) == 0 {
+		r.Password = os.Getenv(prefix + "PASSWORD")
+	}
+}
+
+//serviceAccountToken returns content of token file, or empty token when file does not exist
+func serviceAccountToken(path string) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read service account token: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+//useToken sets service account token as password of registry which has no password neither in flags nor profile
+func useToken(r *registryFlags, registry string, token string) {
+	if len(token) == 0 || len(r.Password) > 0 || isLocalStorage(registry) {
+		return
+	}
+	logrus.WithFields(logrus.Fields{logging.FieldRegistry: registry}).Info("Using Pod Token authentication")
+	r.Password = token
+	if len(r.Username) == 0 {
+		r.Username = tokenUsername
+	}
+}
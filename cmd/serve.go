@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/webhook"
+)
+
+func init() {
+	var rulesFile string
+	var listen string
+	var token string
+	var workers int
+	var retries int
+
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Promote images on Registry push events",
+		Long: `Listen for Registry push notifications and promote pushed images matching the rules.
+                Promotions are queued, de-duplicated and retried on failure.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(rulesFile) == 0 {
+				fmt.Println("Missing rules file, usage: serve --rules rules.yaml")
+				os.Exit(1)
+			}
+			s := &webhook.Server{
+				RulesFile: rulesFile,
+				Listen:    listen,
+				Token:     token,
+				Workers:   workers,
+				Retries:   retries,
+			}
+			if err := s.ServeWebhooks(signalContext()); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&rulesFile, "rules", "", "Promotion rules file")
+	serveCmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen for Registry events on")
+	serveCmd.Flags().StringVar(&token, "token", "", "Require Registry to send \"Authorization: Bearer <token>\" header")
+	serveCmd.Flags().IntVar(&workers, "workers", 1, "Number of concurrent promotions")
+	serveCmd.Flags().IntVar(&retries, "retries", 5, "Number of times failed promotion is retried")
+}
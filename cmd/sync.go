@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vbaksa/promoter/prune"
+	"github.com/vbaksa/promoter/rules"
+	"github.com/vbaksa/promoter/syncer"
+)
+
+func init() {
+	var rulesFile string
+	var interval time.Duration
+	var listen string
+	var src registryFlags
+	var dest registryFlags
+	var tagRegexp string
+	var pruneTags bool
+	var maxPrune int
+
+	var syncCmd = &cobra.Command{
+		Use:   "sync [registry/image] [registry/image]",
+		Short: "Keep image tags in sync",
+		Long: `Periodically push new and changed image tags from one Registry into another one.
+                Runs as a daemon, mappings can be specified by arguments or by rules file.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			s := &syncer.Sync{
+				RulesFile: rulesFile,
+				Interval:  interval,
+				Listen:    listen,
+			}
+			if len(rulesFile) == 0 {
+				if len(args) < 2 {
+					fmt.Println("Missing command arguments, usage: sync [registry/image] [registry/image] or sync --rules rules.yaml")
+					os.Exit(1)
+				}
+				srcRegistry, srcImage, err := ImageNameAndRegistry(args[0])
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				destRegistry, destImage, err := ImageNameAndRegistry(args[1])
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				if err := src.resolve(&srcRegistry); err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				if err := dest.resolve(&destRegistry); err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				//Unlike rules file, tag regexp of command line matches any part of the tag the same way tags command does
+				tagPattern := ""
+				if len(tagRegexp) > 0 {
+					tagPattern = ".*(?:" + tagRegexp + ").*"
+				}
+				s.Rules, err = rules.New(&rules.Rule{
+					Source: rules.Source{
+						Registry:   srcRegistry,
+						Username:   src.Username,
+						Password:   src.Password,
+						Insecure:   src.Insecure,
+						Repository: regexp.QuoteMeta(srcImage),
+						Tag:        tagPattern,
+					},
+					Prune:    pruneTags,
+					MaxPrune: &maxPrune,
+					Destinations: []rules.Destination{{
+						Registry:   destRegistry,
+						Repository: destImage,
+						Username:   dest.Username,
+						Password:   dest.Password,
+						Insecure:   dest.Insecure,
+					}},
+				})
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+			}
+			if err := s.SyncImages(signalContext()); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		},
+	}
+
+	RootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().StringVar(&rulesFile, "rules", "", "Sync rules file")
+	syncCmd.Flags().DurationVar(&interval, "interval", 15*time.Minute, "Interval between syncs of rules without own schedule")
+	syncCmd.Flags().StringVar(&listen, "listen", ":8080", "Address health and readiness endpoints listen on")
+	addRegistryFlags(syncCmd.Flags(), &src, "src-", "Source")
+	addRegistryFlags(syncCmd.Flags(), &dest, "dest-", "Destination")
+	syncCmd.Flags().StringVar(&tagRegexp, "tag-regexp", "", "Filter image tags by specified regexp")
+	syncCmd.Flags().BoolVar(&pruneTags, "prune", false, "Delete destination tags matching tag regexp which no longer exist on Source Registry")
+	syncCmd.Flags().IntVar(&maxPrune, "max-prune", prune.DefaultMaxPrune, "Refuse pruning when more tags would be deleted by a single run, -1 disables the limit")
+}
@@ -1,63 +1,73 @@
 package connection
 
 import (
-	"fmt"
-
-	"os"
+	"context"
+	"net/http"
+	"strings"
 
 	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/logging"
+	"github.com/vbaksa/promoter/registryfs"
 )
 
-type connectionResult struct {
-	srcHub  *registry.Registry
-	destHub *registry.Registry
-	err     error
+//Connect establishes connection to single registry. Failures are returned to the caller
+func Connect(url string, username string, password string, insecure bool) (*registry.Registry, error) {
+	return ConnectContext(context.Background(), url, username, password, insecure, nil)
 }
 
-//InitConnection initializes connections to specified registries
-func InitConnection(srcRegistry string, srcUsername string, srcPassword string, srcInsecure bool, destRegistry string, destUsername string, destPassword string, destInsecure bool) (*registry.Registry, *registry.Registry) {
-	fmt.Println("Establishing connections...")
-	var srcHub *registry.Registry
-	var destHub *registry.Registry
-	res := make(chan *connectionResult)
-	go connect(srcRegistry, srcUsername, srcPassword, srcInsecure, true, res)
-	go connect(destRegistry, destUsername, destPassword, destInsecure, false, res)
-	for index := 0; index < 2; index++ {
-		reg := <-res
-		if reg.err != nil {
-			os.Exit(1)
-		} else {
-			if reg.destHub != nil {
-				destHub = reg.destHub
-			}
-			if reg.srcHub != nil {
-				srcHub = reg.srcHub
-			}
-
+//ConnectContext establishes connection to single registry like Connect. Requests of returned registry are cancelled together with ctx
+//and registry client logs are passed to logf instead of the debug level of the logger
+func ConnectContext(ctx context.Context, url string, username string, password string, insecure bool, logf registry.LogfCallback) (*registry.Registry, error) {
+	if logf == nil {
+		logf = logging.Registry(url)
+	}
+	if registryfs.IsRegistryFS(url) {
+		hub, err := registryfs.NewRegistry(url)
+		if err != nil {
+			return nil, err
 		}
+		hub.Client.Transport = &contextTransport{ctx: ctx, transport: hub.Client.Transport}
+		hub.Logf = logf
+		return hub, nil
 	}
-
-	return srcHub, destHub
+	url = strings.TrimSuffix(url, "/")
+	transport, err := Transport(url, insecure)
+	if err != nil {
+		return nil, err
+	}
+	hub := &registry.Registry{
+		URL: url,
+		Client: &http.Client{
+			Transport: &contextTransport{ctx: ctx, transport: wrapTransport(transport, url, username, password)},
+		},
+		Logf: logf,
+	}
+	if err := hub.Ping(); err != nil {
+		return nil, err
+	}
+	return hub, nil
 }
-func connect(url string, username string, password string, insecure bool, src bool, ch chan *connectionResult) {
-	var hub *registry.Registry
-	var err error
-	res := &connectionResult{}
-	if insecure {
-		hub, err = registry.NewInsecure(url, username, password)
 
-	} else {
-		hub, err = registry.New(url, username, password)
-	}
+//Transport returns unauthenticated transport of registry URL using connection settings of its host.
+//Requests are logged below authentication, so token requests and challenges are logged as well
+func Transport(url string, insecure bool) (http.RoundTripper, error) {
+	s := settingsOf(url)
+	transport, err := s.baseTransport(insecure)
 	if err != nil {
-		res.err = err
-		fmt.Println("Cannot connect to registry: " + url)
-		fmt.Println("Connection error: " + err.Error())
+		return nil, err
 	}
-	if src {
-		res.srcHub = hub
-	} else {
-		res.destHub = hub
+	if s.limit != nil {
+		transport = &rateLimitTransport{limit: s.limit, transport: transport}
 	}
-	ch <- res
+	return &logging.Transport{Registry: url, Transport: transport}, nil
+}
+
+//contextTransport binds every request to context, so cancelling the context aborts requests in flight
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req.WithContext(t.ctx))
 }
@@ -0,0 +1,158 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+//ProxyDirect is Proxy setting connecting to registry without proxy, also when proxy environment variables are set
+const ProxyDirect = "direct"
+
+//configureProxy sets transport to connect through proxy of settings. Hosts matching NoProxy are connected directly by dialer
+func (s *hostSettings) configureProxy(t *http.Transport, direct *net.Dialer) error {
+	switch s.Proxy {
+	case "":
+		t.Proxy = http.ProxyFromEnvironment
+		return nil
+	case ProxyDirect:
+		t.Proxy = nil
+		return nil
+	}
+	u, err := url.Parse(s.Proxy)
+	if err != nil || len(u.Host) == 0 {
+		//Parse error holds the URL, which may carry proxy credentials
+		return errors.New("invalid proxy URL, expected e.g. http://proxy:3128, socks5://bastion:1080 or socks5h://bastion:1080")
+	}
+	bypass := parseNoProxy(s.noProxy())
+	switch u.Scheme {
+	case "http", "https":
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypass.match(req.URL.Host) {
+				return nil, nil
+			}
+			return u, nil
+		}
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", u.Host, auth, direct)
+		if err != nil {
+			return err
+		}
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			if bypass.match(addr) {
+				return direct.DialContext(ctx, network, addr)
+			}
+			return dialContext(ctx, socks, network, addr)
+		}
+	default:
+		return errors.New("unsupported proxy scheme " + u.Scheme + ", expected one of: http, https, socks5, socks5h")
+	}
+	return nil
+}
+
+//dialContext connects through dialer unaware of context. Dialing is abandoned when ctx is done, connection established afterwards is closed
+func dialContext(ctx context.Context, dialer proxy.Dialer, network string, addr string) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := dialer.Dial(network, addr)
+		done <- dialed{conn, err}
+	}()
+	select {
+	case d := <-done:
+		return d.conn, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-done; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+//noProxy returns NoProxy of settings, or NO_PROXY environment variable when not set
+func (s *hostSettings) noProxy() string {
+	if len(s.NoProxy) > 0 {
+		return s.NoProxy
+	}
+	if v := os.Getenv("NO_PROXY"); len(v) > 0 {
+		return v
+	}
+	return os.Getenv("no_proxy")
+}
+
+//noProxyList holds hosts, domains and networks connected without proxy
+type noProxyList struct {
+	all      bool
+	networks []*net.IPNet
+	//hosts are host names or IPs, optionally with port. Names match their subdomains as well
+	hosts []string
+}
+
+//parseNoProxy parses comma separated list in NO_PROXY format e.g. "localhost,.example.com,10.0.0.0/8,registry:5000"
+func parseNoProxy(s string) *noProxyList {
+	l := &noProxyList{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case len(entry) == 0:
+		case entry == "*":
+			l.all = true
+		default:
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				l.networks = append(l.networks, network)
+				continue
+			}
+			l.hosts = append(l.hosts, strings.TrimPrefix(strings.TrimPrefix(entry, "*"), "."))
+		}
+	}
+	return l
+}
+
+//match reports whether host, optionally with port, is connected without proxy
+func (l *noProxyList) match(addr string) bool {
+	if l.all {
+		return true
+	}
+	addr = strings.ToLower(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, entry := range l.hosts {
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if len(entryPort) > 0 && entryPort != port {
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
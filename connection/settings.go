@@ -0,0 +1,108 @@
+package connection
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+//Settings holds connection settings of a registry host which are not part of credentials
+type Settings struct {
+	//CA is path of PEM file with certificate authorities trusted in addition to system ones
+	CA string
+	//RateLimit is maximal number of requests per second, zero means unlimited
+	RateLimit float64
+	//Proxy is URL of http, https or socks5 proxy e.g. socks5://bastion:1080, ProxyDirect disables proxy.
+	//Empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+	Proxy string
+	//NoProxy lists comma separated hosts, domains and networks connected without Proxy, NO_PROXY environment variable is used when empty
+	NoProxy string
+}
+
+type hostSettings struct {
+	Settings
+	limit *rateLimit
+	//transports are shared by all connections to the host, keyed by insecure
+	transports map[bool]*http.Transport
+}
+
+var settings = struct {
+	sync.Mutex
+	hosts map[string]*hostSettings
+}{hosts: make(map[string]*hostSettings)}
+
+//Configure sets connection settings of registry host e.g. registry.example.com:5000, which are used by all later connections to the host.
+//Requests of all connections to the host share the rate limit
+func Configure(host string, s Settings) {
+	hs := &hostSettings{Settings: s}
+	if s.RateLimit > 0 {
+		hs.limit = &rateLimit{interval: time.Duration(float64(time.Second) / s.RateLimit)}
+	}
+	settings.Lock()
+	defer settings.Unlock()
+	if old, ok := settings.hosts[host]; ok {
+		old.closeIdle()
+	}
+	settings.hosts[host] = hs
+}
+
+//settingsOf returns connection settings of registry URL host. Hosts which were not configured get default settings,
+//so their transports are shared as well
+func settingsOf(registryURL string) *hostSettings {
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return &hostSettings{}
+	}
+	settings.Lock()
+	defer settings.Unlock()
+	hs, ok := settings.hosts[u.Host]
+	if !ok {
+		hs = &hostSettings{}
+		settings.hosts[u.Host] = hs
+	}
+	return hs
+}
+
+//rateLimit spaces requests at least interval apart
+type rateLimit struct {
+	sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+//wait blocks until request may be sent or request context is cancelled
+func (l *rateLimit) wait(req *http.Request) error {
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+//rateLimitTransport delays requests exceeding rate limit of registry host
+type rateLimitTransport struct {
+	limit     *rateLimit
+	transport http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limit.wait(req); err != nil {
+		return nil, err
+	}
+	return t.transport.RoundTrip(req)
+}
@@ -0,0 +1,418 @@
+package connection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//DefaultTokenExpiry is lifetime of bearer token whose response does not state expires_in
+const DefaultTokenExpiry = 60 * time.Second
+
+//MaxTokenRefresh is how long before expiry cached bearer token is refreshed at most
+const MaxTokenRefresh = 30 * time.Second
+
+//repositoryPath matches repository name of Registry API request path
+var repositoryPath = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags)/`)
+
+//wrapTransport returns transport authenticating requests to registry URL like registry.WrapTransport,
+//except that bearer tokens are taken from the shared token cache
+func wrapTransport(transport http.RoundTripper, url string, username string, password string) http.RoundTripper {
+	return &registry.ErrorTransport{
+		Transport: &registry.BasicTransport{
+			Transport: &tokenTransport{transport: transport, username: username, password: password},
+			URL:       url,
+			Username:  username,
+			Password:  password,
+		},
+	}
+}
+
+//tokenTransport adds bearer token to registry requests. Token scopes are derived from request, so requests get token before
+//the registry demands it, and scope of registry challenge is requested together with them
+type tokenTransport struct {
+	transport http.RoundTripper
+	username  string
+	password  string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scopes := requestScopes(req)
+	var used *token
+	if c, ok := tokens.challenge(req.URL.Host); ok {
+		tok, err := tokens.get(req.Context(), t, c, scopes)
+		if err != nil {
+			return nil, err
+		}
+		used = tok
+		req = withToken(req, tok)
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	c, ok := parseBearer(resp.Header.Get("Www-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	tokens.setChallenge(req.URL.Host, c)
+	if req.Body != nil {
+		if req.GetBody == nil {
+			//Body was consumed and can not be sent again
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req.Body = body
+	}
+	needed := scopeSet{}
+	needed.add(c.scope)
+	if used != nil && used.scopes.covers(needed) {
+		//Registry refused token which should have granted the scope, so it is not used again
+		tokens.drop(used)
+	}
+	scopes.add(c.scope)
+	resp.Body.Close()
+	tok, err := tokens.get(req.Context(), t, c, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return t.transport.RoundTrip(withToken(req, tok))
+}
+
+//withToken returns copy of request carrying bearer token
+func withToken(req *http.Request, tok *token) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+tok.value)
+	return r
+}
+
+//challenge is bearer authentication challenge of a registry
+type challenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+//parseBearer parses bearer challenge of Www-Authenticate header
+func parseBearer(header string) (challenge, bool) {
+	scheme, params := ParseChallenge(header)
+	if scheme != "bearer" || len(params["realm"]) == 0 {
+		return challenge{}, false
+	}
+	return challenge{realm: params["realm"], service: params["service"], scope: params["scope"]}, true
+}
+
+//ParseChallenge returns lower case scheme and parameters of authentication challenge
+//e.g. Bearer realm="https://auth.example.com/token",service="registry"
+func ParseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return strings.ToLower(header), params
+	}
+	scheme := strings.ToLower(header[:i])
+	rest := header[i+1:]
+	for len(rest) > 0 {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(strings.TrimLeft(rest[:eq], ", ")))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+//scopeSet holds actions granted on resources e.g. repository:library/centos -> pull, push
+type scopeSet map[string]map[string]bool
+
+//requestScopes returns scopes needed by registry request. Mount request needs pull on the source repository as well
+func requestScopes(req *http.Request) scopeSet {
+	s := scopeSet{}
+	m := repositoryPath.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return s
+	}
+	resource := "repository:" + m[1]
+	switch {
+	case req.Method == "GET" || req.Method == "HEAD":
+		s.add(resource + ":pull")
+	case req.Method == "DELETE" && m[2] == "manifests":
+		s.add(resource + ":delete")
+	default:
+		s.add(resource + ":pull,push")
+	}
+	if from := req.URL.Query().Get("from"); len(from) > 0 {
+		s.add("repository:" + from + ":pull")
+	}
+	return s
+}
+
+//add adds scopes of space separated scope strings e.g. "repository:library/centos:pull,push"
+func (s scopeSet) add(scopes string) {
+	for _, scope := range strings.Fields(scopes) {
+		i := strings.LastIndex(scope, ":")
+		if i < 0 {
+			continue
+		}
+		resource := scope[:i]
+		if s[resource] == nil {
+			s[resource] = make(map[string]bool)
+		}
+		for _, action := range strings.Split(scope[i+1:], ",") {
+			if len(action) > 0 {
+				s[resource][action] = true
+			}
+		}
+	}
+}
+
+//covers reports whether all actions of other are granted by s
+func (s scopeSet) covers(other scopeSet) bool {
+	for resource, actions := range other {
+		for action := range actions {
+			if !s[resource][action] && !s[resource]["*"] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//copy returns copy of scopes, so cached token scopes do not change with scopes of request
+func (s scopeSet) copy() scopeSet {
+	c := scopeSet{}
+	for _, scope := range s.strings() {
+		c.add(scope)
+	}
+	return c
+}
+
+//strings returns scopes as sorted scope strings, one per resource
+func (s scopeSet) strings() []string {
+	scopes := make([]string, 0, len(s))
+	for resource, actions := range s {
+		names := make([]string, 0, len(actions))
+		for action := range actions {
+			names = append(names, action)
+		}
+		sort.Strings(names)
+		scopes = append(scopes, resource+":"+strings.Join(names, ","))
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+//token is cached bearer token. Token is being fetched until ready is closed
+type token struct {
+	//key identifies credentials, realm, service and scopes the token was issued for
+	key     string
+	scopes  scopeSet
+	value   string
+	refresh time.Time
+	ready   chan struct{}
+	err     error
+}
+
+//usable reports whether fetched token may still be sent
+func (tok *token) usable() bool {
+	return tok.err == nil && time.Now().Before(tok.refresh)
+}
+
+//fetched reports whether token is no longer being fetched
+func (tok *token) fetched() bool {
+	select {
+	case <-tok.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+//tokenCache holds bearer tokens and challenges shared by all connections, so tokens are fetched once per scope
+//instead of on every 401 response
+var tokens = &tokenCache{challenges: make(map[string]challenge), tokens: make(map[string]*token)}
+
+type tokenCache struct {
+	sync.Mutex
+	challenges map[string]challenge
+	//tokens are keyed by credentials, realm, service and scopes of token request
+	tokens map[string]*token
+}
+
+//challenge returns bearer challenge last seen from registry host
+func (c *tokenCache) challenge(host string) (challenge, bool) {
+	c.Lock()
+	defer c.Unlock()
+	ch, ok := c.challenges[host]
+	return ch, ok
+}
+
+func (c *tokenCache) setChallenge(host string, ch challenge) {
+	c.Lock()
+	defer c.Unlock()
+	ch.scope = ""
+	c.challenges[host] = ch
+}
+
+//drop removes token from cache, unless it was already replaced by another token of the same key
+func (c *tokenCache) drop(tok *token) {
+	c.Lock()
+	defer c.Unlock()
+	if c.tokens[tok.key] == tok {
+		delete(c.tokens, tok.key)
+	}
+}
+
+//evictExpired removes fetched tokens which may no longer be sent. Caller holds the lock
+func (c *tokenCache) evictExpired() {
+	for key, tok := range c.tokens {
+		if tok.fetched() && !tok.usable() {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+//get returns cached token granting scopes, or fetches new one. Concurrent requests wait for token being fetched
+//instead of fetching the same token again
+func (c *tokenCache) get(ctx context.Context, t *tokenTransport, ch challenge, scopes scopeSet) (*token, error) {
+	key := tokenKey(t, ch, scopes)
+	c.Lock()
+	if tok, ok := c.tokens[key]; ok {
+		if !tok.fetched() {
+			c.Unlock()
+			select {
+			case <-tok.ready:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if tok.err != nil {
+				return nil, tok.err
+			}
+			return tok, nil
+		}
+		if tok.usable() {
+			c.Unlock()
+			return tok, nil
+		}
+	}
+	c.evictExpired()
+	tok := &token{key: key, scopes: scopes.copy(), ready: make(chan struct{})}
+	c.tokens[key] = tok
+	c.Unlock()
+	tok.value, tok.refresh, tok.err = fetchToken(ctx, t, ch, scopes)
+	close(tok.ready)
+	if tok.err != nil {
+		c.drop(tok)
+		return nil, tok.err
+	}
+	return tok, nil
+}
+
+//tokenKey identifies token of credentials, realm, service and scopes
+func tokenKey(t *tokenTransport, ch challenge, scopes scopeSet) string {
+	return strings.Join([]string{credentialKey(t.username, t.password), ch.realm, ch.service, strings.Join(scopes.strings(), " ")}, "|")
+}
+
+//credentialKey identifies credentials without keeping the password
+func credentialKey(username string, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+//tokenResponse is response of token server. Older servers return access_token instead of token
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+//fetchToken requests token granting all scopes from token server. Returns token and time when it should be refreshed
+func fetchToken(ctx context.Context, t *tokenTransport, ch challenge, scopes scopeSet) (string, time.Time, error) {
+	u, err := url.Parse(ch.realm)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid token realm %s: %v", ch.realm, err)
+	}
+	q := u.Query()
+	if len(ch.service) > 0 {
+		q.Set("service", ch.service)
+	}
+	for _, scope := range scopes.strings() {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req = req.WithContext(ctx)
+	if len(t.username) > 0 || len(t.password) > 0 {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	started := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token request to %s failed with status %d", ch.realm, resp.StatusCode)
+	}
+	var r tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid token response of %s: %v", ch.realm, err)
+	}
+	if len(r.Token) == 0 {
+		r.Token = r.AccessToken
+	}
+	if len(r.Token) == 0 {
+		return "", time.Time{}, errors.New("token response of " + ch.realm + " holds no token")
+	}
+	expiry := DefaultTokenExpiry
+	if r.ExpiresIn > 0 {
+		expiry = time.Duration(r.ExpiresIn) * time.Second
+	}
+	//Token is refreshed before it expires, so requests in flight do not get rejected
+	margin := expiry / 5
+	if margin > MaxTokenRefresh {
+		margin = MaxTokenRefresh
+	}
+	logrus.WithFields(logrus.Fields{"realm": ch.realm, "scope": strings.Join(scopes.strings(), " "), "expiry": expiry.String()}).Debug("registry.token")
+	return r.Token, started.Add(expiry - margin), nil
+}
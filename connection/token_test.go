@@ -0,0 +1,310 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			header: `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:acme/app:pull"`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:acme/app:pull"},
+		},
+		{
+			header: `Basic realm="Registry Realm"`,
+			scheme: "basic",
+			params: map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			header: `Bearer realm=https://auth.example.com/token,service=registry`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		},
+		{
+			header: `Bearer realm="https://auth.example.com/token", service="registry"`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		},
+		{
+			header: `Bearer realm="https://auth.example.com/token",scope="repository:acme/app:pull,push"`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token", "scope": "repository:acme/app:pull,push"},
+		},
+		{
+			header: `Bearer Realm="https://auth.example.com/token"`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token"},
+		},
+		{
+			header: `Bearer realm="https://auth.example.com/token`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://auth.example.com/token"},
+		},
+		{
+			header: "Negotiate",
+			scheme: "negotiate",
+			params: map[string]string{},
+		},
+		{
+			header: "",
+			scheme: "",
+			params: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		scheme, params := ParseChallenge(test.header)
+		if scheme != test.scheme || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("ParseChallenge(%q) = %q %v, expected %q %v", test.header, scheme, params, test.scheme, test.params)
+		}
+	}
+}
+
+func TestRequestScopes(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		scopes []string
+	}{
+		{"GET", "/v2/acme/app/manifests/1.0", []string{"repository:acme/app:pull"}},
+		{"HEAD", "/v2/acme/app/manifests/1.0", []string{"repository:acme/app:pull"}},
+		{"GET", "/v2/acme/app/tags/list", []string{"repository:acme/app:pull"}},
+		{"HEAD", "/v2/library/centos/blobs/sha256:abc", []string{"repository:library/centos:pull"}},
+		{"PUT", "/v2/acme/app/manifests/1.0", []string{"repository:acme/app:pull,push"}},
+		{"POST", "/v2/acme/app/blobs/uploads/", []string{"repository:acme/app:pull,push"}},
+		{"PATCH", "/v2/acme/app/blobs/uploads/1234", []string{"repository:acme/app:pull,push"}},
+		{"DELETE", "/v2/acme/app/manifests/sha256:abc", []string{"repository:acme/app:delete"}},
+		{"POST", "/v2/prod/app/blobs/uploads/?mount=sha256:abc&from=staging/app", []string{"repository:prod/app:pull,push", "repository:staging/app:pull"}},
+		{"POST", "/v2/acme/app/blobs/uploads/?mount=sha256:abc&from=acme/app", []string{"repository:acme/app:pull,push"}},
+		{"GET", "/v2/", []string{}},
+		{"GET", "/v2/_catalog", []string{}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "https://registry.example.com"+test.url, nil)
+		if scopes := requestScopes(req).strings(); !reflect.DeepEqual(scopes, test.scopes) {
+			t.Errorf("scopes of %s %s are %v, expected %v", test.method, test.url, scopes, test.scopes)
+		}
+	}
+}
+
+//tokenServer is a registry requiring bearer tokens together with its token server
+type tokenServer struct {
+	registry *httptest.Server
+	auth     *httptest.Server
+	//expiresIn is lifetime of issued tokens in seconds
+	expiresIn int
+
+	mutex sync.Mutex
+	//requests holds scopes of every token request
+	requests [][]string
+	valid    map[string]bool
+}
+
+func newTokenServer() *tokenServer {
+	s := &tokenServer{expiresIn: 300, valid: make(map[string]bool)}
+	s.auth = httptest.NewServer(http.HandlerFunc(s.token))
+	s.registry = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *tokenServer) Close() {
+	s.registry.Close()
+	s.auth.Close()
+}
+
+func (s *tokenServer) token(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "promoter" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mutex.Lock()
+	s.requests = append(s.requests, r.URL.Query()["scope"])
+	value := fmt.Sprintf("token-%d", len(s.requests))
+	s.valid[value] = true
+	s.mutex.Unlock()
+	json.NewEncoder(w).Encode(tokenResponse{Token: value, ExpiresIn: s.expiresIn})
+}
+
+func (s *tokenServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	valid := s.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mutex.Unlock()
+	if !valid {
+		scope := strings.Join(requestScopes(r).strings(), " ")
+		w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="%s"`, s.auth.URL, scope))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//revoke makes registry reject every token issued so far
+func (s *tokenServer) revoke() {
+	s.mutex.Lock()
+	s.valid = make(map[string]bool)
+	s.mutex.Unlock()
+}
+
+func (s *tokenServer) tokenRequests() [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]string(nil), s.requests...)
+}
+
+func (s *tokenServer) do(t *testing.T, client *http.Client, method string, path string) {
+	req, err := http.NewRequest(method, s.registry.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s returned status %d", method, path, resp.StatusCode)
+	}
+}
+
+//resetTokens empties token cache shared by all connections
+func resetTokens() {
+	tokens = &tokenCache{challenges: make(map[string]challenge), tokens: make(map[string]*token)}
+}
+
+func newTokenClient() *http.Client {
+	return &http.Client{Transport: &tokenTransport{transport: http.DefaultTransport, username: "promoter", password: "secret"}}
+}
+
+func TestTokenCacheHit(t *testing.T) {
+	resetTokens()
+	s := newTokenServer()
+	defer s.Close()
+	client := newTokenClient()
+
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	s.do(t, client, "HEAD", "/v2/acme/app/manifests/1.1")
+	//Another connection with the same credentials shares the token
+	s.do(t, newTokenClient(), "GET", "/v2/acme/app/tags/list")
+	if requests := s.tokenRequests(); len(requests) != 1 {
+		t.Fatalf("token was requested %d times, expected once: %v", len(requests), requests)
+	}
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	resetTokens()
+	s := newTokenServer()
+	defer s.Close()
+	s.expiresIn = 10
+	client := newTokenClient()
+
+	started := time.Now()
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	tokens.Lock()
+	if len(tokens.tokens) != 1 {
+		tokens.Unlock()
+		t.Fatalf("cache holds %d tokens, expected 1", len(tokens.tokens))
+	}
+	var cached *token
+	for _, tok := range tokens.tokens {
+		cached = tok
+	}
+	tokens.Unlock()
+	//Token of 10 seconds is refreshed 2 seconds before it expires
+	expiry := started.Add(10 * time.Second)
+	if !cached.refresh.Before(expiry.Add(-time.Second)) || cached.refresh.Before(started.Add(7*time.Second)) {
+		t.Fatalf("token expiring at %s is refreshed at %s, expected 2 seconds before expiry", expiry, cached.refresh)
+	}
+
+	//Token still accepted by registry is replaced once its refresh time passed
+	tokens.Lock()
+	cached.refresh = time.Now().Add(-time.Millisecond)
+	tokens.Unlock()
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	if requests := s.tokenRequests(); len(requests) != 2 {
+		t.Fatalf("token was requested %d times, expected refresh", len(requests))
+	}
+	tokens.Lock()
+	defer tokens.Unlock()
+	if len(tokens.tokens) != 1 {
+		t.Fatalf("cache holds %d tokens, expected refreshed token only", len(tokens.tokens))
+	}
+	for _, tok := range tokens.tokens {
+		if tok == cached || tok.value != "token-2" {
+			t.Fatalf("cache holds token %s, expected refreshed token-2", tok.value)
+		}
+	}
+}
+
+func TestRejectedTokenEvicted(t *testing.T) {
+	resetTokens()
+	s := newTokenServer()
+	defer s.Close()
+	client := newTokenClient()
+
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	s.revoke()
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	if requests := s.tokenRequests(); len(requests) != 2 {
+		t.Fatalf("token was requested %d times, expected new token after rejection", len(requests))
+	}
+	tokens.Lock()
+	for _, tok := range tokens.tokens {
+		if tok.value == "token-1" {
+			tokens.Unlock()
+			t.Fatal("rejected token is still cached")
+		}
+	}
+	tokens.Unlock()
+	//New token is cached and used
+	s.do(t, client, "GET", "/v2/acme/app/manifests/1.0")
+	if requests := s.tokenRequests(); len(requests) != 2 {
+		t.Fatalf("token was requested %d times, expected new token to be cached", len(requests))
+	}
+}
+
+func TestMountTokenCombinesScopes(t *testing.T) {
+	resetTokens()
+	s := newTokenServer()
+	defer s.Close()
+	client := newTokenClient()
+
+	//Challenge of the registry is known after first request
+	s.do(t, client, "GET", "/v2/staging/app/manifests/1.0")
+	s.do(t, client, "POST", "/v2/prod/app/blobs/uploads/?mount=sha256:abc&from=staging/app")
+	requests := s.tokenRequests()
+	if len(requests) != 2 {
+		t.Fatalf("token was requested %d times, expected single request for mount: %v", len(requests), requests)
+	}
+	expected := []string{"repository:prod/app:pull,push", "repository:staging/app:pull"}
+	if !reflect.DeepEqual(requests[1], expected) {
+		t.Fatalf("mount token requested scopes %v, expected %v", requests[1], expected)
+	}
+}
+
+func TestTokenWaitCancelled(t *testing.T) {
+	resetTokens()
+	tok := &token{key: "pending", ready: make(chan struct{})}
+	tokens.tokens["pending"] = tok
+	tr := &tokenTransport{}
+	ch := challenge{realm: "https://auth.example.com/token"}
+	//Key of pending token is replaced, so get waits for it
+	tokens.tokens[tokenKey(tr, ch, scopeSet{})] = tok
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tokens.get(ctx, tr, ch, scopeSet{}); err != context.Canceled {
+		t.Fatalf("waiting for pending token returned %v, expected context.Canceled", err)
+	}
+}
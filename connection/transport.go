@@ -0,0 +1,116 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+//Options tune transports shared by all connections. Zero timeout means no timeout
+type Options struct {
+	//DialTimeout limits establishing TCP connection
+	DialTimeout time.Duration
+	//TLSHandshakeTimeout limits TLS handshake
+	TLSHandshakeTimeout time.Duration
+	//ResponseHeaderTimeout limits waiting for response headers after request was sent
+	ResponseHeaderTimeout time.Duration
+	//IdleConnTimeout closes connections idle in pool for longer
+	IdleConnTimeout time.Duration
+	//MaxIdleConnsPerHost is number of idle connections kept in pool of each host
+	MaxIdleConnsPerHost int
+	//DisableHTTP2 uses HTTP/1.1 also when Registry supports HTTP/2
+	DisableHTTP2 bool
+}
+
+//DefaultOptions are used by transports until SetOptions is called
+var DefaultOptions = Options{
+	DialTimeout:         30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	IdleConnTimeout:     90 * time.Second,
+	MaxIdleConnsPerHost: 32,
+}
+
+var options = DefaultOptions
+
+//SetOptions sets options of transports. Transports created before are dropped, so later connections use the options
+func SetOptions(o Options) {
+	settings.Lock()
+	defer settings.Unlock()
+	options = o
+	for _, hs := range settings.hosts {
+		hs.closeIdle()
+		hs.transports = nil
+	}
+}
+
+//baseTransport returns transport of host shared by all its connections, so connections are pooled and reused.
+//Transport verifies certificates with CA of settings in addition to system ones, or accepts all certificates when insecure,
+//and connects through proxy of settings
+func (s *hostSettings) baseTransport(insecure bool) (http.RoundTripper, error) {
+	settings.Lock()
+	defer settings.Unlock()
+	if t, ok := s.transports[insecure]; ok {
+		return t, nil
+	}
+	t, err := s.newTransport(insecure, options)
+	if err != nil {
+		return nil, err
+	}
+	if s.transports == nil {
+		s.transports = make(map[bool]*http.Transport)
+	}
+	s.transports[insecure] = t
+	return t, nil
+}
+
+//newTransport creates transport of host with options
+func (s *hostSettings) newTransport(insecure bool, o Options) (*http.Transport, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if len(s.CA) > 0 {
+		pem, err := ioutil.ReadFile(s.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA file " + s.CA)
+		}
+		config.RootCAs = pool
+	}
+	dialer := &net.Dialer{Timeout: o.DialTimeout, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       config,
+		TLSHandshakeTimeout:   o.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+	}
+	if err := s.configureProxy(t, dialer); err != nil {
+		return nil, err
+	}
+	if !o.DisableHTTP2 {
+		//Transport with custom TLS config or dialer does not negotiate HTTP/2 by itself
+		if err := http2.ConfigureTransport(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+//closeIdle closes idle connections of host transports. Caller holds settings lock
+func (s *hostSettings) closeIdle() {
+	for _, t := range s.transports {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,428 @@
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/archive"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/registryfs"
+)
+
+//Result statuses
+const (
+	Pass = "PASS"
+	//Warn marks missing optional capability, promotion still works
+	Warn = "WARN"
+	Fail = "FAIL"
+	//Skip marks check which does not apply or could not run because an earlier check failed
+	Skip = "SKIP"
+)
+
+//Roles of checked registries
+const (
+	Source      = "source"
+	Destination = "destination"
+)
+
+//ExpiryWarning is remaining validity of certificate reported as warning
+const ExpiryWarning = 30 * 24 * time.Hour
+
+//manifestTypes are accepted manifest media types, promoter handles all of them
+var manifestTypes = []string{
+	archive.MediaTypeOCIManifest,
+	archive.MediaTypeOCIIndex,
+	schema2.MediaTypeManifest,
+	manifestlist.MediaTypeManifestList,
+	schema1.MediaTypeSignedManifest,
+}
+
+//Target is checked registry repository
+type Target struct {
+	//URL of the registry including protocol, or registry-fs:/path of registry storage directory
+	URL        string
+	Repository string
+	//Tag is checked for pull and media types. Empty tag skips these checks
+	Tag      string
+	Username string
+	Password string
+	Insecure bool
+}
+
+//Result is outcome of a single check
+type Result struct {
+	Registry string `json:"registry"`
+	Check    string `json:"check"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+//Failed reports whether any check failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+type checker struct {
+	ctx     context.Context
+	results []Result
+}
+
+func (c *checker) add(role string, check string, status string, detail string) {
+	c.results = append(c.results, Result{Registry: role, Check: check, Status: status, Detail: detail})
+}
+
+//Check runs preflight checks of source and destination: DNS, TLS, API version, authentication, pull and push permissions
+//and served manifest media types. Upload session opened on destination is cancelled, so nothing is pushed.
+//Cross repository mount is checked only when mount is true, as it mounts a source layer into destination repository,
+//which leaves the layer linked there and may create the repository. Mount check is reported as SKIP otherwise
+func Check(ctx context.Context, src Target, dest Target, mount bool) []Result {
+	c := &checker{ctx: ctx}
+	srcHub := c.connect(Source, src, "GET", "/tags/list")
+	var layer digest.Digest
+	if srcHub != nil {
+		if c.pull(src, srcHub) {
+			layer = c.manifest(Source, src, srcHub)
+		}
+	}
+	destHub := c.connect(Destination, dest, "POST", "/blobs/uploads/")
+	if destHub != nil {
+		if c.push(dest, destHub) {
+			c.manifest(Destination, dest, destHub)
+		}
+	}
+	if !mount {
+		c.add(Destination, "Mount", Skip, "not checked, mount probe writes to destination")
+		return c.results
+	}
+	c.mount(src, srcHub, dest, destHub, layer)
+	return c.results
+}
+
+//connect checks DNS, TLS, API version and authentication flow of target. Authentication challenge of repository request
+//given by method and path shows requested scope. Returns authenticated registry, or nil when registry can not be used
+func (c *checker) connect(role string, t Target, method string, path string) *registry.Registry {
+	if registryfs.IsRegistryFS(t.URL) {
+		hub, err := connection.ConnectContext(c.ctx, t.URL, t.Username, t.Password, t.Insecure, nil)
+		if err != nil {
+			c.add(role, "Storage", Fail, err.Error())
+			return nil
+		}
+		c.add(role, "Storage", Pass, "registry storage directory "+strings.TrimPrefix(t.URL, registryfs.Prefix))
+		return hub
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		c.add(role, "DNS", Fail, err.Error())
+		return nil
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		addrs, err := net.DefaultResolver.LookupHost(c.ctx, host)
+		if err != nil {
+			//Registry may still be reachable, proxy resolves names itself
+			c.add(role, "DNS", Fail, err.Error())
+		} else {
+			c.add(role, "DNS", Pass, strings.Join(addrs, ", "))
+		}
+	}
+	transport, err := connection.Transport(t.URL, t.Insecure)
+	if err != nil {
+		c.add(role, "Connect", Fail, err.Error())
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+	resp, err := c.do(client, "GET", t.URL+"/v2/")
+	if err != nil {
+		check := "API"
+		if u.Scheme == "https" && (strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "tls")) {
+			check = "TLS"
+		}
+		c.add(role, check, Fail, err.Error())
+		return nil
+	}
+	resp.Body.Close()
+	c.tls(role, resp.TLS, t.Insecure)
+	version := resp.Header.Get("Docker-Distribution-Api-Version")
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		c.add(role, "API", Fail, fmt.Sprintf("/v2/ returned status %d, not a Docker Registry API v2 endpoint", resp.StatusCode))
+		return nil
+	}
+	if len(version) == 0 {
+		version = "version header missing"
+	}
+	c.add(role, "API", Pass, fmt.Sprintf("/v2/ status %d, %s", resp.StatusCode, version))
+
+	auth := "anonymous access"
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth = describeChallenge(resp.Header.Get("Www-Authenticate"))
+		//Challenge of repository request carries scope the token is requested for
+		if repoResp, err := c.do(client, method, t.URL+"/v2/"+t.Repository+path); err == nil {
+			repoResp.Body.Close()
+			if repoResp.StatusCode == http.StatusUnauthorized {
+				if scope := scopeOf(repoResp.Header.Get("Www-Authenticate")); len(scope) > 0 {
+					auth += " scope=" + scope
+				}
+			} else {
+				cancelUpload(client, t.URL, repoResp)
+			}
+		}
+	}
+	hub, err := connection.ConnectContext(c.ctx, t.URL, t.Username, t.Password, t.Insecure, nil)
+	if err != nil {
+		c.add(role, "Auth", Fail, auth+": "+err.Error())
+		return nil
+	}
+	user := "anonymous"
+	if len(t.Username) > 0 {
+		user = "user " + t.Username
+	}
+	c.add(role, "Auth", Pass, auth+", "+user+" accepted")
+	return hub
+}
+
+//tls reports certificate chain of connection and expiry of its certificates
+func (c *checker) tls(role string, state *tls.ConnectionState, insecure bool) {
+	if state == nil {
+		c.add(role, "TLS", Skip, "plain http")
+		return
+	}
+	chain := state.PeerCertificates
+	if len(state.VerifiedChains) > 0 {
+		chain = state.VerifiedChains[0]
+	}
+	status := Pass
+	names := make([]string, 0, len(chain))
+	for _, cert := range chain {
+		left := time.Until(cert.NotAfter)
+		switch {
+		case left <= 0:
+			status = Fail
+		case left < ExpiryWarning && status == Pass:
+			status = Warn
+		}
+		name := cert.Subject.CommonName
+		if len(name) == 0 {
+			name = cert.Subject.String()
+		}
+		names = append(names, fmt.Sprintf("%s (expires %s)", name, cert.NotAfter.Format("2006-01-02")))
+	}
+	detail := strings.Join(names, " <- ")
+	if insecure {
+		detail += ", not verified"
+	}
+	c.add(role, "TLS", status, detail)
+}
+
+//pull checks source repository tags can be listed
+func (c *checker) pull(t Target, hub *registry.Registry) bool {
+	resp, err := c.do(hub.Client, "GET", hub.URL+"/v2/"+t.Repository+"/tags/list")
+	if err != nil {
+		c.add(Source, "Pull", Fail, describeError(err, "pull"))
+		return false
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		c.add(Source, "Pull", Fail, "invalid tags list: "+err.Error())
+		return false
+	}
+	c.add(Source, "Pull", Pass, fmt.Sprintf("%s has %d tags", t.Repository, len(list.Tags)))
+	return true
+}
+
+//push checks destination repository accepts uploads by opening and cancelling an upload session
+func (c *checker) push(t Target, hub *registry.Registry) bool {
+	resp, err := c.do(hub.Client, "POST", hub.URL+"/v2/"+t.Repository+"/blobs/uploads/")
+	if err != nil {
+		c.add(Destination, "Push", Fail, describeError(err, "push"))
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		c.add(Destination, "Push", Fail, fmt.Sprintf("upload session not started, status %d", resp.StatusCode))
+		return false
+	}
+	if err := cancelUpload(hub.Client, hub.URL, resp); err != nil {
+		c.add(Destination, "Push", Warn, "upload session opened but not cancelled: "+err.Error())
+		return true
+	}
+	c.add(Destination, "Push", Pass, "upload session opened and cancelled")
+	return true
+}
+
+//manifest reports media type served for tag of target. Returns first layer of image manifest, which is used for mount check
+func (c *checker) manifest(role string, t Target, hub *registry.Registry) digest.Digest {
+	if len(t.Tag) == 0 {
+		c.add(role, "Media types", Skip, "no tag given")
+		return ""
+	}
+	req, err := http.NewRequest("GET", hub.URL+"/v2/"+t.Repository+"/manifests/"+t.Tag, nil)
+	if err != nil {
+		c.add(role, "Media types", Fail, err.Error())
+		return ""
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	resp, err := hub.Client.Do(req.WithContext(c.ctx))
+	if err != nil {
+		if statusOf(err) == http.StatusNotFound && role == Destination {
+			c.add(role, "Media types", Skip, "tag "+t.Tag+" does not exist yet")
+			return ""
+		}
+		c.add(role, "Media types", Fail, describeError(err, "pull"))
+		return ""
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.add(role, "Media types", Fail, err.Error())
+		return ""
+	}
+	var m struct {
+		Layers []struct {
+			Digest digest.Digest `json:"digest"`
+		} `json:"layers"`
+		FSLayers []struct {
+			BlobSum digest.Digest `json:"blobSum"`
+		} `json:"fsLayers"`
+	}
+	//Layers are only needed for mount check, manifest lists have none
+	json.Unmarshal(data, &m)
+	mediaType := resp.Header.Get("Content-Type")
+	detail := fmt.Sprintf("%s serves %s", t.Tag, mediaType)
+	switch mediaType {
+	case schema1.MediaTypeSignedManifest, schema1.MediaTypeManifest:
+		c.add(role, "Media types", Warn, detail+", registry does not support schema2 and OCI manifests")
+	default:
+		c.add(role, "Media types", Pass, detail)
+	}
+	switch {
+	case len(m.Layers) > 0:
+		return m.Layers[0].Digest
+	case len(m.FSLayers) > 0:
+		return m.FSLayers[0].BlobSum
+	}
+	return ""
+}
+
+//mount checks registry mounts layer of source repository into destination repository instead of uploading it
+func (c *checker) mount(src Target, srcHub *registry.Registry, dest Target, destHub *registry.Registry, layer digest.Digest) {
+	switch {
+	case srcHub == nil || destHub == nil:
+		c.add(Destination, "Mount", Skip, "registry not available")
+		return
+	case srcHub.URL != destHub.URL:
+		c.add(Destination, "Mount", Skip, "source and destination are different registries, layers are uploaded")
+		return
+	case len(layer) == 0:
+		c.add(Destination, "Mount", Skip, "no source layer, tag not given or manifest list")
+		return
+	}
+	mounted, err := backend.NewRegistry(destHub).MountLayer(dest.Repository, src.Repository, layer)
+	switch {
+	case err != nil:
+		c.add(Destination, "Mount", Warn, describeError(err, "mount"))
+	case !mounted:
+		c.add(Destination, "Mount", Warn, "registry refused cross repository mount, layers are uploaded")
+	default:
+		c.add(Destination, "Mount", Pass, "mounted "+layer.String()+" from "+src.Repository)
+	}
+}
+
+//do sends request bound to context of checker
+func (c *checker) do(client *http.Client, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req.WithContext(c.ctx))
+}
+
+//cancelUpload deletes upload session started by response
+func cancelUpload(client *http.Client, registryURL string, resp *http.Response) error {
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || len(location) == 0 {
+		return nil
+	}
+	if !strings.HasPrefix(location, "http") {
+		location = registryURL + location
+	}
+	req, err := http.NewRequest("DELETE", location, nil)
+	if err != nil {
+		return err
+	}
+	cancel, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	cancel.Body.Close()
+	return nil
+}
+
+//statusOf returns HTTP status of registry client error, or zero
+func statusOf(err error) int {
+	if e, ok := err.(*registry.HttpStatusError); ok {
+		return e.Response.StatusCode
+	}
+	if e, ok := err.(*url.Error); ok {
+		return statusOf(e.Err)
+	}
+	return 0
+}
+
+//describeError explains HTTP status of failed registry request
+func describeError(err error, action string) string {
+	switch statusOf(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return action + " denied, check credentials and repository permissions"
+	case http.StatusNotFound:
+		return "repository or tag not found"
+	case http.StatusMethodNotAllowed:
+		return action + " not allowed, registry may be read only"
+	}
+	return err.Error()
+}
+
+//describeChallenge summarizes authentication challenge e.g. bearer realm=https://auth.example.com/token service=registry
+func describeChallenge(header string) string {
+	if len(header) == 0 {
+		return "no authentication challenge"
+	}
+	scheme, params := connection.ParseChallenge(header)
+	description := scheme
+	for _, key := range []string{"realm", "service"} {
+		if len(params[key]) > 0 {
+			description += " " + key + "=" + params[key]
+		}
+	}
+	return description
+}
+
+//scopeOf returns scope of authentication challenge
+func scopeOf(header string) string {
+	_, params := connection.ParseChallenge(header)
+	return params["scope"]
+}
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/backend"
+)
+
+//Digests holds manifest digests of source tag and destination tag it is promoted to. Destination is empty when destination tag does not exist
+type Digests struct {
+	Source      digest.Digest `json:"source"`
+	Destination digest.Digest `json:"destination"`
+}
+
+//DigestMap remembers digests of promoted tags keyed by destination reference. Promoted manifests are re-signed for destination
+//repository and tag, so digest of renamed tag differs from the source one. Remembered digests let such tags be recognized as unchanged
+//by HEAD requests only. DigestMap is safe for concurrent use, nil DigestMap remembers nothing
+type DigestMap struct {
+	path    string
+	mutex   sync.Mutex
+	digests map[string]Digests
+}
+
+//LoadDigestMap reads digest map file. Missing file gives empty map, the file is created by Save
+func LoadDigestMap(path string) (*DigestMap, error) {
+	m := &DigestMap{path: path, digests: make(map[string]Digests)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.digests); err != nil {
+		return nil, fmt.Errorf("failed to parse digest map %s: %v", path, err)
+	}
+	if m.digests == nil {
+		m.digests = make(map[string]Digests)
+	}
+	return m, nil
+}
+
+//Promoted reports whether destination tag still holds manifest promoted from source manifest of digests
+func (m *DigestMap) Promoted(destRef string, d Digests) bool {
+	if m == nil || len(d.Source) == 0 || len(d.Destination) == 0 {
+		return false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.digests[destRef] == d
+}
+
+//Record remembers digests of promoted destination tag
+func (m *DigestMap) Record(destRef string, d Digests) {
+	if m == nil || len(d.Source) == 0 || len(d.Destination) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	m.digests[destRef] = d
+	m.mutex.Unlock()
+}
+
+//RecordPushed remembers digests of tag pushed from source manifest of srcDigest. Destination digest is requested by HEAD request,
+//as destinations may store the manifest converted. Digests are not remembered when the request fails
+func (m *DigestMap) RecordPushed(destHub backend.Backend, destImage string, destTag string, destRef string, srcDigest digest.Digest) {
+	if m == nil {
+		return
+	}
+	destDigest, err := destHub.ManifestDigest(destImage, destTag)
+	if err == nil {
+		m.Record(destRef, Digests{Source: srcDigest, Destination: destDigest})
+	}
+}
+
+//Save replaces digest map file
+func (m *DigestMap) Save() error {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	data, err := json.MarshalIndent(m.digests, "", "  ")
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), ".digest-map-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write digest map %s: %v", m.path, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
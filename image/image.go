@@ -1,11 +1,11 @@
 package image
 
 import (
-	"io/ioutil"
-	"log"
+	"context"
+	"errors"
 
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
@@ -13,10 +13,11 @@ import (
 
 	"github.com/docker/libtrust"
 	"github.com/dustin/go-humanize"
-	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/backend"
 	"github.com/vbaksa/promoter/layer"
-
-	"gopkg.in/cheggaaa/pb.v1"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/progressbar"
+	"github.com/vbaksa/promoter/report"
 )
 
 //Promote holds promotion structure used to hold promotion parameters
@@ -24,79 +25,176 @@ type Promote struct {
 	SrcRegistry  string
 	SrcImage     string
 	SrcImageTag  string
-	SrcUsername  string
-	SrcPassword  string
-	SrcInsecure  bool
 	DestRegistry string
 	DestImage    string
 	DestImageTag string
-	DestUsername string
-	DestPassword string
-	DestInsecure bool
-	Debug        bool
+	Overwrite    string
+	Force        bool
+	//Progress receives status messages and progress bars, they are printed to stdout when Progress is nil
+	Progress progress.Progress
+	//Result describes the promotion once Push returns
+	Result *report.Tag
+	//Transferred holds number of layer bytes transferred by Push
+	Transferred int64
+	//Promoted remembers digests of promoted tags, so unchanged tags promoted under another name are skipped by HEAD requests only.
+	//Nil Promoted remembers nothing
+	Promoted *DigestMap
 }
 
-//PromoteImage is used to execute specified promotion structure
-func (pr *Promote) PromoteImage() {
-	if !pr.Debug {
-		log.SetOutput(ioutil.Discard)
+//Push promotes image between already connected registries. Failures are returned to the caller, so it can be used by long running commands
+func (pr *Promote) Push(ctx context.Context, srcHub backend.Backend, destHub backend.Backend) error {
+	pr.Result = &report.Tag{
+		Source:      report.Reference(pr.SrcRegistry, pr.SrcImage, pr.SrcImageTag),
+		Destination: report.Reference(pr.DestRegistry, pr.DestImage, pr.DestImageTag),
+		Status:      report.TagUnchanged,
+	}
+	pr.Transferred = 0
+	pr.Progress = progress.Or(pr.Progress)
+	started := time.Now()
+	err := pr.push(ctx, srcHub, destHub)
+	pr.Result.DurationSeconds = time.Since(started).Seconds()
+	if err != nil {
+		pr.Result.Fail(err)
+		pr.Progress.Event(progress.Event{Type: progress.EventTagFailed, Registry: pr.SrcRegistry, Repository: pr.SrcImage, Tag: pr.SrcImageTag, Digest: pr.Result.SourceDigest.String(), Error: err.Error()})
 	}
-	fmt.Println("Preparing Image Push")
-	srcHub, destHub := connection.InitConnection(pr.SrcRegistry, pr.SrcUsername, pr.SrcPassword, pr.SrcInsecure, pr.DestRegistry, pr.DestUsername, pr.DestPassword, pr.DestInsecure)
-	fmt.Println("Source image: " + pr.SrcImage + ":" + pr.SrcImageTag)
-	fmt.Println("Destination image: " + pr.DestImage + ":" + pr.DestImageTag)
+	return err
+}
 
+func (pr *Promote) push(ctx context.Context, srcHub backend.Backend, destHub backend.Backend) error {
+	p := pr.Progress
+	p.Printf("Source image: %s:%s\n", pr.SrcImage, pr.SrcImageTag)
+	p.Printf("Destination image: %s:%s\n", pr.DestImage, pr.DestImageTag)
+
+	destRef := report.Reference(pr.DestRegistry, pr.DestImage, pr.DestImageTag)
+	digests, headErr := HeadDigests(srcHub, pr.SrcImage, pr.SrcImageTag, destHub, pr.DestImage, pr.DestImageTag)
+	if headErr == nil && Unchanged(digests, pr.Promoted, destRef) {
+		p.Printf("Destination tag is unchanged, skipping push\n")
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	srcManifest, err := srcHub.Manifest(pr.SrcImage, pr.SrcImageTag)
 	if err != nil {
-		fmt.Println("Failed to download Source Image manifest. Error: " + err.Error())
-		os.Exit(1)
+		return errors.New("Failed to download Source Image manifest. Error: " + err.Error())
+	}
+	pr.Result.SourceDigest = digest.FromBytes(srcManifest.Canonical)
+	p.Event(progress.Event{Type: progress.EventManifestResolved, Registry: pr.SrcRegistry, Repository: pr.SrcImage, Tag: pr.SrcImageTag, Digest: pr.Result.SourceDigest.String()})
+
+	//Destination tag known not to exist needs no comparison
+	if headErr != nil || len(digests.Destination) > 0 {
+		skip, err := CheckOverwrite(destHub, pr.DestImage, pr.DestImageTag, srcManifest, pr.Overwrite, pr.Force)
+		if err != nil {
+			return err
+		}
+		if skip {
+			if headErr == nil {
+				pr.Promoted.Record(destRef, digests)
+			}
+			p.Printf("Destination tag already holds the same image, skipping push\n")
+			return nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	srcLayers := srcManifest.FSLayers
-	fmt.Println("Optimising upload...")
-	uploadLayer := layer.MissingLayers(destHub, pr.DestImage, srcLayers)
+	layers := make(map[digest.Digest]*report.Layer)
+	for _, l := range srcLayers {
+		if _, ok := layers[l.BlobSum]; !ok {
+			layers[l.BlobSum] = &report.Layer{Digest: l.BlobSum, Status: report.LayerSkipped}
+			pr.Result.Layers = append(pr.Result.Layers, layers[l.BlobSum])
+		}
+	}
+	p.Printf("Optimising upload...\n")
+	mounted, uploadLayer := layer.MountLayers(destHub, pr.DestImage, srcHub, pr.SrcImage, layer.MissingLayers(destHub, pr.DestImage, srcLayers, p))
+	for _, l := range mounted {
+		layers[l].Status = report.LayerMounted
+	}
+	if len(mounted) > 0 {
+		p.Printf("Mounted %d layers from Source Image \n", len(mounted))
+	}
+	upload := make(map[digest.Digest]bool)
+	for _, l := range uploadLayer {
+		upload[l] = true
+	}
+	for _, l := range pr.Result.Layers {
+		if l.Status == report.LayerMounted {
+			p.Event(progress.Event{Type: progress.EventLayerSkipped, Registry: pr.DestRegistry, Repository: pr.DestImage, Digest: l.Digest.String(), Reason: progress.ReasonMounted})
+		} else if !upload[l.Digest] {
+			p.Event(progress.Event{Type: progress.EventLayerSkipped, Registry: pr.DestRegistry, Repository: pr.DestImage, Digest: l.Digest.String(), Reason: progress.ReasonExists})
+		}
+	}
 	if len(uploadLayer) > 0 {
-		totalDownloadSize := layer.DigestSize(srcHub, pr.SrcImage, uploadLayer)
-		fmt.Println()
-		fmt.Printf("Going to upload around %s of layer data. Expected network bandwidth: %s \n", humanize.Bytes(uint64(totalDownloadSize)), humanize.Bytes(uint64(totalDownloadSize*2)))
-		fmt.Println()
+		sizes, err := layer.LayerSizes(srcHub, pr.SrcImage, uploadLayer)
+		if err != nil {
+			return errors.New("Failed to inspect Source Image layers. Error: " + err.Error())
+		}
+		var totalDownloadSize int64
+		for l, size := range sizes {
+			layers[l].Size = size
+			totalDownloadSize = totalDownloadSize + size
+		}
+		p.Printf("\n")
+		p.Printf("Going to upload around %s of layer data. Expected network bandwidth: %s \n", humanize.Bytes(uint64(totalDownloadSize)), humanize.Bytes(uint64(totalDownloadSize*2)))
+		p.Printf("\n")
 
-		fmt.Println()
-		fmt.Println("Uploading layers")
-		fmt.Println()
+		p.Printf("\n")
+		p.Printf("Uploading layers\n")
+		p.Printf("\n")
 
-		done := make(chan bool)
-		var totalReader = make(chan int64)
+		type transferResult struct {
+			layer digest.Digest
+			err   error
+		}
+		done := make(chan transferResult)
+		total := &progressbar.Counter{}
+		bar := p.Stage(progress.StageUpload, totalDownloadSize, progress.Bytes)
 		for _, l := range uploadLayer {
 			go func(l digest.Digest) {
-				layer.UploadLayerWithProgress(destHub, pr.DestImage, srcHub, pr.SrcImage, l, &totalReader)
-				done <- true
+				err := ctx.Err()
+				if err == nil {
+					err = layer.TransferLayer(destHub, pr.DestImage, srcHub, pr.SrcImage, l, p.Transfer(pr.DestImage, l.String(), sizes[l]), total)
+				}
+				if err != nil {
+					err = fmt.Errorf("Error occurred while uploading layer: %s. Error: %v", l, err)
+				}
+				done <- transferResult{layer: l, err: err}
 			}(l)
 		}
-		bar := pb.New64(totalDownloadSize * 2).SetUnits(pb.U_BYTES)
-		bar.Start()
-		go func() {
-			for {
-				t := <-totalReader
-				bar.Add64(t * 2)
-			}
-		}()
+		sampler := total.Sample(progressbar.SampleInterval, bar.Add)
 
+		var uploadErr error
 		for i := 0; i < len(uploadLayer); i++ {
-			<-done
+			res := <-done
+			layers[res.layer].Status = report.LayerUploaded
+			if res.err != nil {
+				layers[res.layer].Status = report.LayerFailed
+				layers[res.layer].Error = res.err.Error()
+				if uploadErr == nil {
+					uploadErr = res.err
+				}
+			}
 		}
+		sampler.Stop()
+		pr.Transferred = pr.Transferred + total.Value()
 		bar.Finish()
+		if uploadErr != nil {
+			return uploadErr
+		}
 
-		fmt.Println("Finished uploading layers")
+		p.Printf("Finished uploading layers\n")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	fmt.Println("Generating Signing Key...")
+	p.Printf("Generating Signing Key...\n")
 	key, err := libtrust.GenerateECP256PrivateKey()
 	if err != nil {
-		fmt.Println("Error occurred while generating Image Key")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return errors.New("Error occurred while generating Image Key. Error: " + err.Error())
 	}
-	fmt.Println("Signing Image Manifest...")
+	p.Printf("Signing Image Manifest...\n")
 	destManifest := srcManifest
 	destManifest.Name = pr.DestImage
 	destManifest.Tag = pr.DestImageTag
@@ -113,18 +211,20 @@ func (pr *Promote) PromoteImage() {
 	}
 	signedManifest, err := manifestV1.Sign(manifest, key)
 	if err != nil {
-		fmt.Println("Error occurred while Signing Image Manifest")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return errors.New("Error occurred while Signing Image Manifest. Error: " + err.Error())
 	}
 
-	fmt.Println("Submitting Image Manifest")
+	p.Printf("Submitting Image Manifest\n")
 	err = destHub.PutManifest(pr.DestImage, pr.DestImageTag, signedManifest)
 
 	if err != nil {
-		fmt.Println("Manifest update error: " + err.Error())
-		os.Exit(1)
+		return errors.New("Manifest update error: " + err.Error())
+	}
+	pr.Result.DestinationDigest = digest.FromBytes(signedManifest.Canonical)
+	pr.Result.Status = report.TagPushed
+	if headErr == nil {
+		pr.Promoted.RecordPushed(destHub, pr.DestImage, pr.DestImageTag, destRef, digests.Source)
 	}
-	fmt.Println("Push Complete")
-	os.Exit(0)
+	p.Event(progress.Event{Type: progress.EventManifestPushed, Registry: pr.DestRegistry, Repository: pr.DestImage, Tag: pr.DestImageTag, Digest: pr.Result.DestinationDigest.String()})
+	return nil
 }
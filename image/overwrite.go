@@ -0,0 +1,106 @@
+package image
+
+import (
+	"fmt"
+
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/vbaksa/promoter/backend"
+)
+
+//Overwrite policies applied when destination tag already exists
+const (
+	//OverwriteAlways replaces existing destination tags
+	OverwriteAlways = "always"
+	//OverwriteNever refuses to replace existing destination tags
+	OverwriteNever = "never"
+	//OverwriteIfDifferent reports conflict for destination tags holding different image
+	OverwriteIfDifferent = "if-different"
+)
+
+//ValidOverwrite reports whether overwrite policy is known. Empty policy means OverwriteAlways
+func ValidOverwrite(policy string) bool {
+	switch policy {
+	case "", OverwriteAlways, OverwriteNever, OverwriteIfDifferent:
+		return true
+	}
+	return false
+}
+
+//CheckOverwrite applies overwrite policy to destination tag. Returns true when destination already holds the same image, such tag is unchanged and push can be skipped under every policy.
+//Tag holding different image is replaced by OverwriteAlways, refused by OverwriteNever and reported as conflict by OverwriteIfDifferent unless force is set.
+//Destination manifest is downloaded without checking the tag by HEAD request first, a missing tag costs the same single request.
+//Comparing images costs one manifest GET per destination tag under every policy, since tag holding the same image is skipped even by OverwriteAlways.
+//Callers knowing from HeadDigests that destination tag does not exist push without calling it
+func CheckOverwrite(destHub backend.Backend, destImage string, destTag string, src *manifestV1.SignedManifest, policy string, force bool) (bool, error) {
+	dest, err := destHub.Manifest(destImage, destTag)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		if policy == "" || policy == OverwriteAlways {
+			//Image can not be compared, replace it as before
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot check destination tag %s:%s: %v", destImage, destTag, err)
+	}
+	if SameImage(src, dest) {
+		return true, nil
+	}
+	switch policy {
+	case OverwriteNever:
+		return false, fmt.Errorf("destination tag %s:%s already exists and overwrite is disabled", destImage, destTag)
+	case OverwriteIfDifferent:
+		if !force {
+			return false, fmt.Errorf("conflict: destination tag %s:%s holds different image, use --force to replace it", destImage, destTag)
+		}
+	}
+	return false, nil
+}
+
+//HeadDigests returns manifest digests of source and destination tag using HEAD requests, so no manifest has to be downloaded.
+//Destination digest is empty when destination tag does not exist. Source digest is requested before source manifest is downloaded,
+//so digest recorded after promotion never describes newer source image than the promoted one
+func HeadDigests(srcHub backend.Backend, srcImage string, srcTag string, destHub backend.Backend, destImage string, destTag string) (Digests, error) {
+	destDigest, err := destHub.ManifestDigest(destImage, destTag)
+	if err != nil && !IsNotFound(err) {
+		return Digests{}, err
+	}
+	srcDigest, err := srcHub.ManifestDigest(srcImage, srcTag)
+	if err != nil {
+		return Digests{}, err
+	}
+	return Digests{Source: srcDigest, Destination: destDigest}, nil
+}
+
+//Unchanged reports whether destination tag holds the source image. Digests are equal when destination manifest was promoted under
+//the same repository and tag name. Renamed tags are re-signed, they are recognized by digests promoted remembers from earlier promotion
+func Unchanged(d Digests, promoted *DigestMap, destRef string) bool {
+	if len(d.Destination) == 0 {
+		return false
+	}
+	return d.Source == d.Destination || promoted.Promoted(destRef, d)
+}
+
+//SameImage compares layers and image history of two schema1 manifests ignoring name, tag and signatures.
+//Promoted manifests carry destination name and tag, so their digests match the source only when the names are the same
+func SameImage(a *manifestV1.SignedManifest, b *manifestV1.SignedManifest) bool {
+	if len(a.FSLayers) != len(b.FSLayers) || len(a.History) != len(b.History) {
+		return false
+	}
+	for i := range a.FSLayers {
+		if a.FSLayers[i].BlobSum != b.FSLayers[i].BlobSum {
+			return false
+		}
+	}
+	for i := range a.History {
+		if a.History[i].V1Compatibility != b.History[i].V1Compatibility {
+			return false
+		}
+	}
+	return true
+}
+
+//IsNotFound reports whether Registry request failed because requested object does not exist
+func IsNotFound(err error) bool {
+	return backend.IsNotFound(err)
+}
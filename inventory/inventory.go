@@ -0,0 +1,215 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/progress"
+)
+
+//Inventory records blobs present on a Registry together with repositories holding them
+type Inventory struct {
+	Registry     string                     `json:"registry"`
+	Created      time.Time                  `json:"created"`
+	Repositories []string                   `json:"repositories"`
+	Blobs        map[digest.Digest][]string `json:"blobs"`
+}
+
+//Has reports whether blob is recorded in the inventory
+func (inv *Inventory) Has(d digest.Digest) bool {
+	_, ok := inv.Blobs[d]
+	return ok
+}
+
+//Load reads inventory file
+func Load(path string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %v", path, err)
+	}
+	if inv.Blobs == nil {
+		inv.Blobs = make(map[digest.Digest][]string)
+	}
+	return inv, nil
+}
+
+//Write writes inventory as JSON
+func (inv *Inventory) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+//Collect holds inventory collection structure
+type Collect struct {
+	Registry     string
+	Username     string
+	Password     string
+	Insecure     bool
+	Repositories []string
+	Output       string
+	//Progress receives status messages, Console when nil
+	Progress progress.Progress
+}
+
+type repositoryBlobs struct {
+	repository string
+	blobs      []digest.Digest
+	err        error
+}
+
+//CollectInventory records blob digests referenced by all tags of specified repositories.
+//Manifests are read the way Registry stores them, so config and layer blobs of schema2, OCI and multi-platform images are recorded.
+//Inventory is written to stdout when no Output is set, failed repositories are left out of it and reported by returned error
+func (c *Collect) CollectInventory() error {
+	p := progress.Or(c.Progress)
+	hub, err := connection.Connect(c.Registry, c.Username, c.Password, c.Insecure)
+	if err != nil {
+		return fmt.Errorf("cannot connect to registry %s: %v", c.Registry, err)
+	}
+	b := backend.NewRegistry(hub)
+	repositories := c.Repositories
+	if len(repositories) == 0 {
+		p.Printf("No repositories specified, reading Registry catalog\n")
+		repositories, err = b.Repositories()
+		if err != nil {
+			return fmt.Errorf("error occurred while trying to get Registry catalog: %v", err)
+		}
+	}
+
+	inv := &Inventory{
+		Registry:     c.Registry,
+		Created:      time.Now().UTC(),
+		Repositories: repositories,
+		Blobs:        make(map[digest.Digest][]string),
+	}
+	failed := make([]string, 0)
+	for _, repository := range repositories {
+		res := repositoryInventory(b, repository)
+		if res.err != nil {
+			p.Printf("Failed to inventory repository %s. Error: %s\n", repository, res.err.Error())
+			failed = append(failed, repository)
+			continue
+		}
+		for _, d := range res.blobs {
+			inv.Blobs[d] = append(inv.Blobs[d], repository)
+		}
+		p.Printf("Repository %s contains %d blobs\n", repository, len(res.blobs))
+	}
+	for _, repos := range inv.Blobs {
+		sort.Strings(repos)
+	}
+	p.Printf("Inventory contains %d blobs\n", len(inv.Blobs))
+
+	if err := c.write(inv); err != nil {
+		return fmt.Errorf("cannot write inventory: %v", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to inventory repositories: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c *Collect) write(inv *Inventory) error {
+	if len(c.Output) == 0 {
+		return inv.Write(os.Stdout)
+	}
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	if err := inv.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//repositoryInventory returns unique blobs referenced by all repository tags
+func repositoryInventory(b backend.Backend, repository string) *repositoryBlobs {
+	tags, err := b.Tags(repository)
+	if err != nil {
+		return &repositoryBlobs{repository: repository, err: err}
+	}
+	//TO-DO parametrize number of connections
+	manifestGetQueue := tunny.NewFunc(5, func(payload interface{}) interface{} {
+		tag := payload.(string)
+		blobs, err := manifestBlobs(b, repository, tag, make(map[string]bool))
+		if err != nil {
+			return &repositoryBlobs{repository: repository, err: fmt.Errorf("cannot get manifest of tag %s: %v", tag, err)}
+		}
+		return &repositoryBlobs{repository: repository, blobs: blobs}
+	})
+	defer manifestGetQueue.Close()
+
+	resultChannel := make(chan *repositoryBlobs)
+	for _, tag := range tags {
+		go func(tag string) {
+			resultChannel <- manifestGetQueue.Process(tag).(*repositoryBlobs)
+		}(tag)
+	}
+	seen := make(map[digest.Digest]bool)
+	result := &repositoryBlobs{repository: repository, blobs: make([]digest.Digest, 0)}
+	for i := 0; i < len(tags); i++ {
+		res := <-resultChannel
+		if res.err != nil {
+			result.err = res.err
+			continue
+		}
+		for _, d := range res.blobs {
+			if !seen[d] {
+				seen[d] = true
+				result.blobs = append(result.blobs, d)
+			}
+		}
+	}
+	return result
+}
+
+//manifestBlobs returns config and layer blobs of stored manifest. Index manifests are followed into every child manifest.
+//Foreign layers are skipped since clients pull them from their own URLs
+func manifestBlobs(b backend.Backend, repository string, reference string, visited map[string]bool) ([]digest.Digest, error) {
+	if visited[reference] {
+		return nil, nil
+	}
+	visited[reference] = true
+	mediaType, payload, err := b.RawManifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := backend.ParseReferences(mediaType, payload)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]digest.Digest, 0, len(refs.Blobs))
+	for _, desc := range refs.Blobs {
+		if len(desc.URLs) == 0 {
+			blobs = append(blobs, desc.Digest)
+		}
+	}
+	for _, m := range refs.Manifests {
+		child, err := manifestBlobs(b, repository, m.Digest.String(), visited)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, child...)
+	}
+	return blobs, nil
+}
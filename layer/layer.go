@@ -2,13 +2,14 @@ package layer
 
 import (
 	"fmt"
-	"os"
+	"io"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	manifestV1 "github.com/docker/distribution/manifest/schema1"
 	humanize "github.com/dustin/go-humanize"
-	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/progress"
 	"github.com/vbaksa/promoter/progressbar"
 )
 
@@ -25,7 +26,23 @@ type existingLayer struct {
 }
 
 //MissingLayers computes list of layers required to be uploaded. Upload is optimized by skipping existing layers
-func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []manifestV1.FSLayer) []digest.Digest {
+func MissingLayers(destHub backend.Backend, destImage string, srcLayers []manifestV1.FSLayer, p progress.Progress) []digest.Digest {
+	digests := make([]digest.Digest, 0, len(srcLayers))
+	for _, layer := range srcLayers {
+		digests = append(digests, layer.BlobSum)
+	}
+	results, totalSaved := MissingDigests(destHub, destImage, digests, p)
+	p.Printf("\n")
+	if totalSaved > 100 {
+		p.Printf("Some layers already exist on Remote Registry. Skipping around %s of layer data. Total network bandwidth saved: %s \n", humanize.Bytes(uint64(totalSaved)), humanize.Bytes(uint64(totalSaved*2)))
+	}
+	p.Printf("\n")
+
+	return results
+}
+
+//MissingDigests returns blobs which do not exist on destination image together with total size of the existing ones
+func MissingDigests(destHub backend.Backend, destImage string, digests []digest.Digest, p progress.Progress) ([]digest.Digest, int64) {
 
 	//Layers array returned by function
 	results := make([]digest.Digest, 0)
@@ -35,24 +52,23 @@ func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []man
 	result := make(chan *layerCheckResult)
 
 	// check each layer on remote hub
-	for _, layer := range srcLayers {
-		go func(layer manifestV1.FSLayer, result chan *layerCheckResult) {
+	for _, layer := range digests {
+		go func(layer digest.Digest, result chan *layerCheckResult) {
 
-			layerMetada, err := destHub.LayerMetadata(destImage, layer.BlobSum)
+			layerMetada, err := destHub.LayerMetadata(destImage, layer)
 			if err != nil {
 				// Layer does not exist
-				//	fmt.Println("Layer does not exist: " + layer.BlobSum)
 				checkResult := &layerCheckResult{
 					Err: err,
 					Missing: &missingLayer{
-						Blob: layer.BlobSum,
+						Blob: layer,
 					},
 				}
 				result <- checkResult
 
 			} else {
 				// Layer exists
-				fmt.Println("Layer already exists on Remote Registry: " + layerMetada.Digest)
+				p.Printf("Layer already exists on Remote Registry: %s\n", layerMetada.Digest)
 				checkResult := &layerCheckResult{
 					Err: nil,
 					Exists: &existingLayer{
@@ -65,7 +81,7 @@ func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []man
 	}
 
 	// Wait for result (each layer check)
-	for i := 0; i < len(srcLayers); i++ {
+	for i := 0; i < len(digests); i++ {
 		res := <-result
 		// If we got result about missing layer on remote registry, else check result about existing layer
 		if res.Missing != nil {
@@ -85,55 +101,89 @@ func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []man
 			totalSaved = totalSaved + res.Exists.Descriptor.Size
 		}
 	}
-	fmt.Println()
-	if totalSaved > 100 {
-		fmt.Printf("Some layers already exist on Remote Registry. Skipping around %s of layer data. Total network bandwidth saved: %s \n", humanize.Bytes(uint64(totalSaved)), humanize.Bytes(uint64(totalSaved*2)))
-	}
-	fmt.Println()
+	return results, totalSaved
+}
 
-	return results
+//LayersSize returns total upload size or the first layer inspection error
+func LayersSize(srcHub backend.Backend, srcImage string, uploadLayer []digest.Digest) (int64, error) {
+	sizes, err := LayerSizes(srcHub, srcImage, uploadLayer)
+	var total int64
+	for _, size := range sizes {
+		total = total + size
+	}
+	return total, err
 }
 
-//DigestSize returns total upload size
-func DigestSize(srcHub *registry.Registry, srcImage string, uploadLayer []digest.Digest) int64 {
-	result := make(chan distribution.Descriptor)
-	for _, layer := range uploadLayer {
+//LayerSizes returns size of every layer or the first layer inspection error
+func LayerSizes(srcHub backend.Backend, srcImage string, layers []digest.Digest) (map[digest.Digest]int64, error) {
+	type sizeResult struct {
+		layer digest.Digest
+		size  int64
+		err   error
+	}
+	result := make(chan sizeResult)
+	for _, layer := range layers {
 		go func(layer digest.Digest) {
 			l, err := srcHub.LayerMetadata(srcImage, layer)
 			if err != nil {
-				fmt.Println("Error while inspecting layer: " + layer)
-				fmt.Println("Error: " + err.Error())
-				os.Exit(1)
+				err = fmt.Errorf("cannot inspect layer %s: %v", layer, err)
 			}
-			result <- l
+			result <- sizeResult{layer: layer, size: l.Size, err: err}
 		}(layer)
 	}
-	var total int64
-	for i := 0; i < len(uploadLayer); i++ {
+	sizes := make(map[digest.Digest]int64)
+	var err error
+	for i := 0; i < len(layers); i++ {
 		r := <-result
-		total = total + r.Size
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		sizes[r.layer] = r.size
 	}
-	return total
+	return sizes, err
 }
 
-//UploadLayer uploads image layer with option to track upload progress
-func UploadLayer(destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, layer digest.Digest) {
-	UploadLayerWithProgress(destHub, destImage, srcHub, srcImage, layer, nil)
-}
-
-//UploadLayerWithProgress uploads image layer with option to track upload progress
-func UploadLayerWithProgress(destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, layer digest.Digest, totalReader *chan int64) {
+//TransferLayer streams image layer from Source Registry into Destination Registry and reports failures instead of exiting.
+//Streamed bytes are counted once as downloaded while they are read. They are reported as uploaded after Destination Registry accepted the layer,
+//since the same bytes are sent as soon as they are read. Transfer is finished once the layer is transferred
+func TransferLayer(destHub backend.Backend, destImage string, srcHub backend.Backend, srcImage string, layer digest.Digest, transfer progress.Transfer, total *progressbar.Counter) (err error) {
+	defer func() {
+		transfer.Finish(err)
+	}()
 	reader, err := srcHub.DownloadLayer(srcImage, layer)
+	if err != nil {
+		return err
+	}
 	defer reader.Close()
-	if totalReader != nil {
-		rd := &progressbar.PassThru{ReadCloser: reader, Total: totalReader}
-		destHub.UploadLayer(destImage, layer, rd)
-	} else {
-		destHub.UploadLayer(destImage, layer, reader)
+	var sent int64
+	var content io.ReadCloser = &progress.Reader{ReadCloser: reader, Count: func(n int64) {
+		sent += n
+		transfer.Downloaded(n)
+	}}
+	if total != nil {
+		content = &progressbar.PassThru{ReadCloser: content, Total: total}
 	}
-	if err != nil {
-		fmt.Println("Error occurred while uploading layer: " + layer)
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+	if err := destHub.UploadLayer(destImage, layer, content); err != nil {
+		return err
+	}
+	transfer.Uploaded(sent)
+	return nil
+}
+
+//MountLayers mounts layers from Source Image when both images are stored in the same Registry, so they are not transferred.
+//Returns mounted layers and layers which still have to be uploaded
+func MountLayers(destHub backend.Backend, destImage string, srcHub backend.Backend, srcImage string, layers []digest.Digest) ([]digest.Digest, []digest.Digest) {
+	if srcHub.Name() != destHub.Name() || srcImage == destImage {
+		return nil, layers
+	}
+	mounted := make([]digest.Digest, 0)
+	upload := make([]digest.Digest, 0)
+	for _, l := range layers {
+		if ok, err := destHub.MountLayer(destImage, srcImage, l); err == nil && ok {
+			mounted = append(mounted, l)
+		} else {
+			upload = append(upload, l)
+		}
 	}
+	return mounted, upload
 }
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const redacted = "[redacted]"
+
+//sensitiveParams are parts of query parameter names whose values are never logged, e.g. access_token or X-Amz-Signature
+var sensitiveParams = []string{"token", "password", "secret", "signature", "credential", "key", "code"}
+
+//Transport logs summary of every HTTP request at debug level: method, URL, authorization scheme, status, duration and authentication challenge.
+//Bodies and header values carrying credentials are never logged, credentials in URLs are redacted
+type Transport struct {
+	Registry  string
+	Transport http.RoundTripper
+}
+
+//RoundTrip performs request and logs its summary
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Debug() {
+		return t.Transport.RoundTrip(req)
+	}
+	started := time.Now()
+	resp, err := t.Transport.RoundTrip(req)
+	fields := logrus.Fields{
+		FieldRegistry:   t.Registry,
+		"method":        req.Method,
+		"url":           SanitizeURL(req.URL.String()),
+		"authorization": authScheme(req.Header.Get("Authorization")),
+		"duration":      time.Since(started).String(),
+	}
+	if err != nil {
+		fields["error"] = sanitizeError(err)
+		logrus.WithFields(fields).Debug("http.request")
+		return resp, err
+	}
+	fields["status"] = resp.StatusCode
+	if challenge := resp.Header.Get("Www-Authenticate"); len(challenge) > 0 {
+		fields["challenge"] = challenge
+	}
+	if location := resp.Header.Get("Location"); len(location) > 0 {
+		fields["location"] = SanitizeURL(location)
+	}
+	logrus.WithFields(fields).Debug("http.request")
+	return resp, nil
+}
+
+//SanitizeURL removes user info and redacts values of query parameters which may carry credentials
+func SanitizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	query := u.Query()
+	changed := false
+	for name := range query {
+		if isSensitive(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveParams {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//authScheme returns scheme of Authorization header without credentials, e.g. Bearer
+func authScheme(authorization string) string {
+	if len(authorization) == 0 {
+		return "none"
+	}
+	return strings.SplitN(authorization, " ", 2)[0]
+}
+
+//sanitizeError redacts URL of request errors, which may carry credentials
+func sanitizeError(err error) string {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Op + " " + SanitizeURL(ue.URL) + ": " + ue.Err.Error()
+	}
+	return err.Error()
+}
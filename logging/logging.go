@@ -0,0 +1,110 @@
+//Package logging routes diagnostics of promoter into a single levelled logger. Entries carry registry, repo, tag and digest fields
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+//Fields shared by log entries
+const (
+	FieldRegistry = "registry"
+	FieldRepo     = "repo"
+	FieldTag      = "tag"
+	FieldDigest   = "digest"
+)
+
+//Setup configures level and format of the logrus standard logger writing into stderr.
+//Messages of the standard library logger are passed to debug level
+func Setup(level string, format string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return errors.New("Invalid log level " + level + ", expected one of: debug, info, warning, error")
+	}
+	switch format {
+	case "", FormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return errors.New("Invalid log format " + format + ", expected one of: text, json")
+	}
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(l)
+	log.SetFlags(0)
+	log.SetOutput(stdlibWriter{})
+	return nil
+}
+
+//Debug reports whether debug entries are logged, so callers can skip building them
+func Debug() bool {
+	return logrus.GetLevel() >= logrus.DebugLevel
+}
+
+//Image returns entry with registry and repo fields
+func Image(registryURL string, repo string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{FieldRegistry: registryURL, FieldRepo: repo})
+}
+
+//Registry returns registry client Logf logging requests at debug level with registry field.
+//Key value pairs of client messages become fields, e.g. repository=library/centos is logged as repo field
+func Registry(registryURL string) registry.LogfCallback {
+	return func(format string, args ...interface{}) {
+		if !Debug() {
+			return
+		}
+		message, fields := parseMessage(fmt.Sprintf(format, args...))
+		fields[FieldRegistry] = registryURL
+		logrus.WithFields(fields).Debug(message)
+	}
+}
+
+//parseMessage splits registry client message "registry.manifest.get url=... repository=... reference=..." into message and fields
+func parseMessage(line string) (string, logrus.Fields) {
+	fields := logrus.Fields{}
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return line, fields
+	}
+	for _, word := range words[1:] {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) < 2 {
+			continue
+		}
+		switch kv[0] {
+		case "repository":
+			fields[FieldRepo] = kv[1]
+		case "reference":
+			if strings.Contains(kv[1], ":") {
+				fields[FieldDigest] = kv[1]
+			} else {
+				fields[FieldTag] = kv[1]
+			}
+		case "url":
+			fields["url"] = SanitizeURL(kv[1])
+		default:
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return words[0], fields
+}
+
+//stdlibWriter passes lines of the standard library logger to debug level
+type stdlibWriter struct{}
+
+func (stdlibWriter) Write(p []byte) (int, error) {
+	logrus.Debug(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,345 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gosuri/uiprogress"
+	"github.com/mattn/go-isatty"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+//ConsoleInterval is the period of plain text progress lines printed when stdout is not a terminal
+var ConsoleInterval = 10 * time.Second
+
+//Console prints messages and progress to Out. When Out is a terminal progress bars are drawn, upload stage shows one bar per transferred layer.
+//Otherwise progress is printed as plain text line every ConsoleInterval, so CI logs are not flooded by redrawn bars
+type Console struct {
+	//Out receives messages and progress, stdout when nil
+	Out io.Writer
+}
+
+func (c Console) out() io.Writer {
+	if c.Out == nil {
+		return os.Stdout
+	}
+	return c.Out
+}
+
+//console holds upload stage currently displayed. Terminal is shared by all Console values
+var console struct {
+	sync.Mutex
+	upload uploadDisplay
+}
+
+type uploadDisplay interface {
+	transfer(layer string, size int64) Transfer
+}
+
+//Printf prints message to Out
+func (c Console) Printf(format string, args ...interface{}) {
+	console.Lock()
+	live, ok := console.upload.(*liveUpload)
+	console.Unlock()
+	if ok {
+		//Message is printed above the bars
+		fmt.Fprintf(live.bypass, format, args...)
+		return
+	}
+	fmt.Fprintf(c.out(), format, args...)
+}
+
+//Stage starts progress bar, or plain text progress when Out is not a terminal
+func (c Console) Stage(name string, total int64, unit Unit) Bar {
+	out := c.out()
+	if !isTerminal(out) {
+		return startTextStage(out, name, total, unit)
+	}
+	if unit == Bytes {
+		return startLiveUpload(out, name, total)
+	}
+	bar := pb.New64(total).SetUnits(pb.U_NO)
+	bar.Output = out
+	bar.Start()
+	return &consoleBar{bar: bar}
+}
+
+//Transfer adds layer to upload stage display
+func (Console) Transfer(repository string, layer string, size int64) Transfer {
+	console.Lock()
+	defer console.Unlock()
+	if console.upload == nil {
+		return nopTransfer{}
+	}
+	return console.upload.transfer(layer, size)
+}
+
+//Event is ignored, events are described by printed messages
+func (Console) Event(e Event) {}
+
+//isTerminal reports whether w is a terminal. Writers other than files never are
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+//setUpload registers display receiving layer transfers
+func setUpload(d uploadDisplay) {
+	console.Lock()
+	defer console.Unlock()
+	console.upload = d
+}
+
+//clearUpload unregisters display unless upload stage of another promotion replaced it
+func clearUpload(d uploadDisplay) {
+	console.Lock()
+	defer console.Unlock()
+	if console.upload == d {
+		console.upload = nil
+	}
+}
+
+type consoleBar struct {
+	bar *pb.ProgressBar
+}
+
+func (b *consoleBar) Add(n int64) {
+	b.bar.Add64(n)
+}
+
+func (b *consoleBar) Finish() {
+	b.bar.Finish()
+}
+
+type nopTransfer struct{}
+
+func (nopTransfer) Downloaded(n int64) {}
+func (nopTransfer) Uploaded(n int64)   {}
+func (nopTransfer) Finish(err error)   {}
+
+//textStage prints progress line every ConsoleInterval and when stage finishes.
+//Byte counters are updated atomically, so concurrent transfers don't contend for the stage lock
+type textStage struct {
+	done       int64
+	downloaded int64
+	uploaded   int64
+	sync.Mutex
+	out     io.Writer
+	name    string
+	unit    Unit
+	total   int64
+	active  int
+	stop    chan bool
+	stopped chan bool
+}
+
+func startTextStage(out io.Writer, name string, total int64, unit Unit) *textStage {
+	s := &textStage{out: out, name: name, unit: unit, total: total, stop: make(chan bool), stopped: make(chan bool)}
+	if unit == Bytes {
+		setUpload(s)
+	}
+	go func() {
+		ticker := time.NewTicker(ConsoleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintln(s.out, s.line())
+			case <-s.stop:
+				close(s.stopped)
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *textStage) Add(n int64) {
+	atomic.AddInt64(&s.done, n)
+}
+
+func (s *textStage) Finish() {
+	if s.unit == Bytes {
+		clearUpload(s)
+	}
+	close(s.stop)
+	<-s.stopped
+	fmt.Fprintln(s.out, s.line())
+}
+
+func (s *textStage) line() string {
+	s.Lock()
+	defer s.Unlock()
+	done := atomic.LoadInt64(&s.done)
+	var percent int64
+	if s.total > 0 {
+		percent = done * 100 / s.total
+	}
+	if s.unit != Bytes {
+		return fmt.Sprintf("%s: %d/%d (%d%%)", s.name, done, s.total, percent)
+	}
+	return fmt.Sprintf("%s: %s/%s (%d%%), downloaded %s, uploaded %s, %d layers in progress", s.name, humanize.Bytes(uint64(done)), humanize.Bytes(uint64(s.total)), percent,
+		humanize.Bytes(uint64(atomic.LoadInt64(&s.downloaded))), humanize.Bytes(uint64(atomic.LoadInt64(&s.uploaded))), s.active)
+}
+
+func (s *textStage) transfer(layer string, size int64) Transfer {
+	s.Lock()
+	defer s.Unlock()
+	s.active++
+	return &textTransfer{stage: s}
+}
+
+type textTransfer struct {
+	stage *textStage
+	once  sync.Once
+}
+
+func (t *textTransfer) Downloaded(n int64) {
+	atomic.AddInt64(&t.stage.downloaded, n)
+}
+
+func (t *textTransfer) Uploaded(n int64) {
+	atomic.AddInt64(&t.stage.uploaded, n)
+}
+
+func (t *textTransfer) Finish(err error) {
+	t.once.Do(func() {
+		t.stage.Lock()
+		defer t.stage.Unlock()
+		t.stage.active--
+	})
+}
+
+//liveUpload draws total upload bar followed by one bar per layer transferred at the same time
+type liveUpload struct {
+	sync.Mutex
+	progress *uiprogress.Progress
+	bypass   io.Writer
+	total    int64
+	done     int64
+	bar      *uiprogress.Bar
+	slots    []*layerSlot
+}
+
+//layerSlot is a bar reused by layers transferred one after another. Byte counters are updated atomically
+type layerSlot struct {
+	downloaded int64
+	sync.Mutex
+	bar    *uiprogress.Bar
+	layer  string
+	size   int64
+	active bool
+}
+
+func startLiveUpload(out io.Writer, name string, total int64) *liveUpload {
+	p := uiprogress.New()
+	p.SetOut(out)
+	p.SetRefreshInterval(100 * time.Millisecond)
+	p.Width = 30
+	u := &liveUpload{progress: p, bypass: p.Bypass(), total: total}
+	u.bar = p.AddBar(100)
+	u.bar.PrependFunc(func(b *uiprogress.Bar) string {
+		return fmt.Sprintf("%-26s", name)
+	})
+	u.bar.AppendFunc(func(b *uiprogress.Bar) string {
+		u.Lock()
+		defer u.Unlock()
+		return humanize.Bytes(uint64(u.done)) + " / " + humanize.Bytes(uint64(u.total))
+	})
+	u.bar.Set(percentOf(0, total))
+	p.Start()
+	setUpload(u)
+	return u
+}
+
+func (u *liveUpload) Add(n int64) {
+	u.Lock()
+	u.done = u.done + n
+	percent := percentOf(u.done, u.total)
+	u.Unlock()
+	u.bar.Set(percent)
+}
+
+func (u *liveUpload) Finish() {
+	clearUpload(u)
+	u.progress.Stop()
+}
+
+func (u *liveUpload) transfer(layer string, size int64) Transfer {
+	u.Lock()
+	defer u.Unlock()
+	for _, slot := range u.slots {
+		slot.Lock()
+		if !slot.active {
+			slot.start(layer, size)
+			slot.Unlock()
+			slot.bar.Set(0)
+			return slot
+		}
+		slot.Unlock()
+	}
+	slot := &layerSlot{}
+	slot.start(layer, size)
+	slot.bar = u.progress.AddBar(100)
+	slot.bar.PrependFunc(func(b *uiprogress.Bar) string {
+		slot.Lock()
+		defer slot.Unlock()
+		return fmt.Sprintf("  %-24s", shortDigest(slot.layer))
+	})
+	slot.bar.AppendFunc(func(b *uiprogress.Bar) string {
+		slot.Lock()
+		defer slot.Unlock()
+		if !slot.active {
+			return "done"
+		}
+		return fmt.Sprintf("%s of %s", humanize.Bytes(uint64(atomic.LoadInt64(&slot.downloaded))), humanize.Bytes(uint64(slot.size)))
+	})
+	u.slots = append(u.slots, slot)
+	return slot
+}
+
+func (s *layerSlot) start(layer string, size int64) {
+	s.layer = layer
+	s.size = size
+	atomic.StoreInt64(&s.downloaded, 0)
+	s.active = true
+}
+
+func (s *layerSlot) Downloaded(n int64) {
+	downloaded := atomic.AddInt64(&s.downloaded, n)
+	s.Lock()
+	percent := percentOf(downloaded, s.size)
+	s.Unlock()
+	s.bar.Set(percent)
+}
+
+//Uploaded is not shown, bar follows streamed bytes and finishes once the layer is accepted
+func (s *layerSlot) Uploaded(n int64) {}
+
+func (s *layerSlot) Finish(err error) {
+	s.Lock()
+	s.active = false
+	s.Unlock()
+	s.bar.Set(100)
+}
+
+func percentOf(done int64, total int64) int {
+	if total <= 0 || done >= total {
+		return 100
+	}
+	return int(done * 100 / total)
+}
+
+//shortDigest shortens layer digest to algorithm and first 12 characters of hex
+func shortDigest(layer string) string {
+	if i := strings.Index(layer, ":"); i >= 0 && len(layer) > i+13 {
+		return layer[:i+13]
+	}
+	return layer
+}
@@ -0,0 +1,48 @@
+package progress
+
+import "time"
+
+//Progress formats of commands
+const (
+	//FormatText prints status messages and progress bars
+	FormatText = "text"
+	//FormatJSONL writes events as JSON lines
+	FormatJSONL = "jsonl"
+)
+
+//Promotion event types
+const (
+	EventConnected        = "connection-established"
+	EventManifestResolved = "manifest-resolved"
+	EventLayerSkipped     = "layer-skipped"
+	EventLayerStarted     = "layer-started"
+	EventLayerBytes       = "layer-bytes"
+	EventLayerDone        = "layer-done"
+	EventManifestPushed   = "manifest-pushed"
+	EventTagFailed        = "tag-failed"
+	EventMessage          = "message"
+)
+
+//Reasons of skipped layers
+const (
+	//ReasonExists means layer already exists in destination repository
+	ReasonExists = "exists"
+	//ReasonMounted means layer was mounted from source repository of the same Registry
+	ReasonMounted = "mounted"
+)
+
+//Event describes promotion step. Fields not related to the event are empty
+type Event struct {
+	Type       string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Registry   string    `json:"registry,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Downloaded int64     `json:"downloaded,omitempty"`
+	Uploaded   int64     `json:"uploaded,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
@@ -0,0 +1,102 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//BytesInterval is the minimal period between layer-bytes events of a single layer
+var BytesInterval = 500 * time.Millisecond
+
+//JSONL writes events as newline delimited JSON. Status messages are written as message events, stage progress is covered by layer events
+type JSONL struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+//NewJSONL returns progress writing events into w
+func NewJSONL(w io.Writer) *JSONL {
+	return &JSONL{encoder: json.NewEncoder(w)}
+}
+
+//Printf writes message event
+func (j *JSONL) Printf(format string, args ...interface{}) {
+	message := strings.TrimSpace(fmt.Sprintf(format, args...))
+	if len(message) > 0 {
+		j.Event(Event{Type: EventMessage, Message: message})
+	}
+}
+
+//Stage ignores stage progress
+func (j *JSONL) Stage(name string, total int64, unit Unit) Bar {
+	return nopBar{}
+}
+
+//Transfer writes layer-started event, layer-bytes events at most every BytesInterval and layer-done event
+func (j *JSONL) Transfer(repository string, layer string, size int64) Transfer {
+	j.Event(Event{Type: EventLayerStarted, Repository: repository, Digest: layer, Size: size})
+	return &jsonlTransfer{jsonl: j, repository: repository, layer: layer, size: size, reported: time.Now().UnixNano()}
+}
+
+//Event writes event as a single line
+func (j *JSONL) Event(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.encoder.Encode(e)
+}
+
+type nopBar struct{}
+
+func (nopBar) Add(n int64) {}
+func (nopBar) Finish()     {}
+
+//jsonlTransfer counts bytes atomically. Only the reader winning the report slot after BytesInterval writes layer-bytes event
+type jsonlTransfer struct {
+	downloaded int64
+	uploaded   int64
+	//reported is time of the last layer-bytes event in Unix nanoseconds
+	reported   int64
+	jsonl      *JSONL
+	repository string
+	layer      string
+	size       int64
+}
+
+func (t *jsonlTransfer) Downloaded(n int64) {
+	atomic.AddInt64(&t.downloaded, n)
+	t.report()
+}
+
+func (t *jsonlTransfer) Uploaded(n int64) {
+	atomic.AddInt64(&t.uploaded, n)
+	t.report()
+}
+
+func (t *jsonlTransfer) report() {
+	reported := atomic.LoadInt64(&t.reported)
+	now := time.Now().UnixNano()
+	if time.Duration(now-reported) < BytesInterval || !atomic.CompareAndSwapInt64(&t.reported, reported, now) {
+		return
+	}
+	t.jsonl.Event(t.event(EventLayerBytes))
+}
+
+func (t *jsonlTransfer) Finish(err error) {
+	e := t.event(EventLayerDone)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.jsonl.Event(e)
+}
+
+func (t *jsonlTransfer) event(eventType string) Event {
+	return Event{Type: eventType, Repository: t.repository, Digest: t.layer, Size: t.size, Downloaded: atomic.LoadInt64(&t.downloaded), Uploaded: atomic.LoadInt64(&t.uploaded)}
+}
@@ -0,0 +1,177 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+//Unit of stage progress
+type Unit int
+
+const (
+	//Count counts processed items, e.g. tags or layers
+	Count Unit = iota
+	//Bytes counts transferred bytes
+	Bytes
+)
+
+//Promotion stages reported by Stage
+const (
+	StageManifests = "manifests"
+	StageOverwrite = "overwrite"
+	StageLayers    = "layers"
+	StageUpload    = "upload"
+	StageDeploy    = "deploy"
+)
+
+//Progress receives status messages and progress of promotion stages. Implementations have to be safe for concurrent use
+type Progress interface {
+	//Printf receives human readable status message
+	Printf(format string, args ...interface{})
+	//Stage starts promotion stage with expected total
+	Stage(name string, total int64, unit Unit) Bar
+	//Transfer starts tracking transfer of a single layer into repository during upload stage
+	Transfer(repository string, layer string, size int64) Transfer
+	//Event receives promotion event
+	Event(e Event)
+}
+
+//Bar tracks progress of a single stage
+type Bar interface {
+	Add(n int64)
+	Finish()
+}
+
+//Transfer tracks transfer of a single layer. Layers are streamed, so every downloaded byte is sent to Destination Registry right away
+type Transfer interface {
+	//Downloaded adds number of bytes read from Source Registry and streamed into Destination Registry
+	Downloaded(n int64)
+	//Uploaded adds number of bytes Destination Registry accepted, it is called once the layer upload completes
+	Uploaded(n int64)
+	//Finish ends the transfer, err is nil when layer was transferred
+	Finish(err error)
+}
+
+//Or returns p, or Console when p is nil
+func Or(p Progress) Progress {
+	if p == nil {
+		return Console{}
+	}
+	return p
+}
+
+//Discard ignores all progress
+var Discard Progress = &Callbacks{}
+
+//Callbacks reports progress to optional functions. Functions may be called concurrently
+type Callbacks struct {
+	//Message receives human readable status message without trailing new line
+	Message func(message string)
+	//Progress receives number of processed items or bytes of a stage. It is called once more with done set to total when the stage finishes
+	Progress func(stage string, done int64, total int64)
+	//Layer receives downloaded and uploaded bytes of a transferred layer. It is called once more when the transfer finishes
+	Layer func(layer string, downloaded int64, uploaded int64, size int64)
+	//Events receives promotion events
+	Events func(e Event)
+}
+
+//Printf formats message and passes it to Message callback
+func (c *Callbacks) Printf(format string, args ...interface{}) {
+	if c.Message == nil {
+		return
+	}
+	message := strings.TrimRight(fmt.Sprintf(format, args...), " \n")
+	if len(message) > 0 {
+		c.Message(message)
+	}
+}
+
+//Stage starts reporting stage progress to Progress callback
+func (c *Callbacks) Stage(name string, total int64, unit Unit) Bar {
+	return &callbackBar{callbacks: c, stage: name, total: total}
+}
+
+type callbackBar struct {
+	sync.Mutex
+	callbacks *Callbacks
+	stage     string
+	done      int64
+	total     int64
+}
+
+func (b *callbackBar) Add(n int64) {
+	if b.callbacks.Progress == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.done = b.done + n
+	b.callbacks.Progress(b.stage, b.done, b.total)
+}
+
+func (b *callbackBar) Finish() {
+	if b.callbacks.Progress != nil {
+		b.callbacks.Progress(b.stage, b.total, b.total)
+	}
+}
+
+//Transfer starts reporting layer transfer to Layer callback
+func (c *Callbacks) Transfer(repository string, layer string, size int64) Transfer {
+	return &callbackTransfer{callbacks: c, layer: layer, size: size}
+}
+
+type callbackTransfer struct {
+	sync.Mutex
+	callbacks  *Callbacks
+	layer      string
+	size       int64
+	downloaded int64
+	uploaded   int64
+}
+
+func (t *callbackTransfer) Downloaded(n int64) {
+	t.update(n, 0)
+}
+
+func (t *callbackTransfer) Uploaded(n int64) {
+	t.update(0, n)
+}
+
+func (t *callbackTransfer) update(downloaded int64, uploaded int64) {
+	if t.callbacks.Layer == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.downloaded = t.downloaded + downloaded
+	t.uploaded = t.uploaded + uploaded
+	t.callbacks.Layer(t.layer, t.downloaded, t.uploaded, t.size)
+}
+
+func (t *callbackTransfer) Finish(err error) {
+	t.update(0, 0)
+}
+
+//Event passes event to Events callback
+func (c *Callbacks) Event(e Event) {
+	if c.Events != nil {
+		c.Events(e)
+	}
+}
+
+//Reader counts bytes read through it
+type Reader struct {
+	io.ReadCloser
+	Count func(n int64)
+}
+
+//Read reads from underlying reader and counts read bytes
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.Count(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+package progressbar
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//SampleInterval is how often Sampler passes counted bytes to progress display
+const SampleInterval = 100 * time.Millisecond
+
+//Counter counts transferred bytes with atomic operations, so concurrent copies counting into it never wait for each other
+type Counter struct {
+	n int64
+}
+
+//Add adds n bytes
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.n, n)
+}
+
+//Value returns number of bytes counted so far
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+//Sampler periodically passes growth of Counter to progress display
+type Sampler struct {
+	counter *Counter
+	add     func(n int64)
+	last    int64
+	stop    chan bool
+	stopped chan bool
+}
+
+//Sample starts passing bytes counted since the previous sample to add every interval until the Sampler is stopped.
+//add is never called concurrently
+func (c *Counter) Sample(interval time.Duration, add func(n int64)) *Sampler {
+	s := &Sampler{counter: c, add: add, stop: make(chan bool), stopped: make(chan bool)}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				s.sample()
+				close(s.stopped)
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *Sampler) sample() {
+	value := s.counter.Value()
+	if value > s.last {
+		s.add(value - s.last)
+		s.last = value
+	}
+}
+
+//Stop passes bytes counted since the last sample and waits until sampling goroutine exits
+func (s *Sampler) Stop() {
+	close(s.stop)
+	<-s.stopped
+}
@@ -0,0 +1,136 @@
+package progressbar
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//chunk is size of a single read counted during layer transfer
+const chunk = 32 * 1024
+
+//channelCounter counts bytes the way transfers did before Counter: every read sends its size to a goroutine updating the display
+type channelCounter struct {
+	reads chan int64
+	total int64
+	done  chan bool
+}
+
+func newChannelCounter() *channelCounter {
+	c := &channelCounter{reads: make(chan int64), done: make(chan bool)}
+	go func() {
+		for n := range c.reads {
+			c.total = c.total + n
+		}
+		close(c.done)
+	}()
+	return c
+}
+
+func (c *channelCounter) Add(n int64) {
+	c.reads <- n
+}
+
+func (c *channelCounter) close() int64 {
+	close(c.reads)
+	<-c.done
+	return c.total
+}
+
+func BenchmarkChannelCounterParallel(b *testing.B) {
+	c := newChannelCounter()
+	b.SetBytes(chunk)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(chunk)
+		}
+	})
+	b.StopTimer()
+	if total := c.close(); total != int64(b.N)*chunk {
+		b.Fatalf("counted %d bytes, expected %d", total, int64(b.N)*chunk)
+	}
+}
+
+func BenchmarkCounterParallel(b *testing.B) {
+	c := &Counter{}
+	b.SetBytes(chunk)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(chunk)
+		}
+	})
+	b.StopTimer()
+	if total := c.Value(); total != int64(b.N)*chunk {
+		b.Fatalf("counted %d bytes, expected %d", total, int64(b.N)*chunk)
+	}
+}
+
+func BenchmarkSampledCounterParallel(b *testing.B) {
+	c := &Counter{}
+	var sampled int64
+	s := c.Sample(SampleInterval, func(n int64) {
+		sampled = sampled + n
+	})
+	b.SetBytes(chunk)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(chunk)
+		}
+	})
+	s.Stop()
+	b.StopTimer()
+	if sampled != int64(b.N)*chunk {
+		b.Fatalf("sampled %d bytes, expected %d", sampled, int64(b.N)*chunk)
+	}
+}
+
+func TestSamplerStopFlushesFinalCount(t *testing.T) {
+	c := &Counter{}
+	var sampled int64
+	var calls int
+	//Interval never elapses, so only Stop passes the count
+	s := c.Sample(time.Hour, func(n int64) {
+		sampled = sampled + n
+		calls++
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Add(chunk)
+			}
+		}()
+	}
+	wg.Wait()
+	s.Stop()
+	if expected := int64(8 * 100 * chunk); sampled != expected {
+		t.Fatalf("sampled %d bytes, expected %d", sampled, expected)
+	}
+	if calls != 1 {
+		t.Fatalf("add called %d times, expected once by Stop", calls)
+	}
+}
+
+func TestSamplerPassesGrowthOnly(t *testing.T) {
+	c := &Counter{}
+	samples := make(chan int64, 10)
+	s := c.Sample(time.Millisecond, func(n int64) {
+		samples <- n
+	})
+	c.Add(10)
+	if n := <-samples; n != 10 {
+		t.Fatalf("first sample is %d, expected 10", n)
+	}
+	c.Add(5)
+	if n := <-samples; n != 5 {
+		t.Fatalf("second sample is %d, expected growth of 5", n)
+	}
+	s.Stop()
+	select {
+	case n := <-samples:
+		t.Fatalf("unexpected sample %d after counter stopped growing", n)
+	default:
+	}
+}
@@ -10,7 +10,7 @@ import (
 // the results from individual calls to it.
 type PassThru struct {
 	io.ReadCloser
-	Total *chan int64 // Total # of bytes transferred
+	Total *Counter // Total # of bytes transferred
 }
 
 // Read 'overrides' the underlying io.ReadCloser's Read method.
@@ -20,7 +20,9 @@ func (pt *PassThru) Read(p []byte) (int, error) {
 
 	n, err := pt.ReadCloser.Read(p)
 
-	*pt.Total <- int64(n)
+	if n > 0 {
+		pt.Total.Add(int64(n))
+	}
 
 	return n, err
 }
@@ -0,0 +1,265 @@
+//Package promote promotes Docker images between registries from other Go programs.
+//Promotions never print to stdout or exit the process, failures are returned and progress is reported through callbacks
+package promote
+
+import (
+	"context"
+	"io"
+
+	"github.com/vbaksa/promoter/archive"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/report"
+	"github.com/vbaksa/promoter/tags"
+)
+
+//ErrNoTagsSelected is returned by Tags when tag or image selectors didn't match any tags
+var ErrNoTagsSelected = tags.ErrNoTagsSelected
+
+//FailedError is returned by Tags when some tags failed to push. Report describes error of each tag
+type FailedError = tags.FailedError
+
+//ConnectionError is returned when registry could not be connected
+type ConnectionError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return "cannot connect to registry " + e.URL + ": " + e.Err.Error()
+}
+
+//Registry describes how to connect to Docker Registry
+type Registry struct {
+	//URL of the registry including protocol e.g. https://registry.example.com, registry-fs:/path of registry storage directory,
+	//oci:/path of OCI image layout directory or oci-archive:/path of OCI image layout tarball
+	URL      string
+	Username string
+	Password string
+	//Insecure accepts all certificates
+	Insecure bool
+}
+
+//ImageOptions describes promotion of a single image tag
+type ImageOptions struct {
+	Source           Registry
+	SourceImage      string
+	SourceTag        string
+	Destination      Registry
+	DestinationImage string
+	DestinationTag   string
+	//Overwrite is one of image.OverwriteNever, image.OverwriteIfDifferent or image.OverwriteAlways. Empty means always
+	Overwrite string
+	Force     bool
+	//DigestMap is file remembering source and destination manifest digests of promoted tags, so unchanged tags promoted under
+	//another repository or tag name are skipped by HEAD requests only. File is created when missing, empty means no file
+	DigestMap string
+	//Progress receives status messages and stage progress, e.g. &progress.Callbacks{}. Nothing is reported when Progress is nil
+	Progress progress.Progress
+	//Logf receives debug logs of registry requests. Logs are passed to the debug level of logrus standard logger when Logf is nil
+	Logf func(format string, args ...interface{})
+}
+
+//TagsOptions describes promotion of selected image tags. Empty selectors select all tags
+type TagsOptions struct {
+	Source           Registry
+	SourceImage      string
+	Destination      Registry
+	DestinationImage string
+	TagRegexp        string
+	TagExcludeRegexp string
+	TagSemver        string
+	Latest           int
+	TagsFrom         string
+	NewerThan        string
+	OlderThan        string
+	Labels           []string
+	Overwrite        string
+	Force            bool
+	DestTagTemplate  string
+	Prune            bool
+	//MaxPrune refuses pruning when more tags would be deleted, negative value disables the limit
+	MaxPrune int
+	DryRun   bool
+	//DigestMap is file remembering source and destination manifest digests of promoted tags, so unchanged tags promoted under
+	//another repository or tag name are skipped by HEAD requests only. File is created when missing, empty means no file
+	DigestMap string
+	//Progress receives status messages and stage progress, e.g. &progress.Callbacks{}. Nothing is reported when Progress is nil
+	Progress progress.Progress
+	//Logf receives debug logs of registry requests. Logs are passed to the debug level of logrus standard logger when Logf is nil
+	Logf func(format string, args ...interface{})
+}
+
+//Image promotes single image tag. Cancelling ctx aborts requests in flight.
+//Report describes the promotion also when error is returned, unless registries could not be connected
+func Image(ctx context.Context, opts ImageOptions) (*report.Report, error) {
+	p := orDiscard(opts.Progress)
+	promoted, err := loadDigestMap(opts.DigestMap)
+	if err != nil {
+		return nil, err
+	}
+	srcHub, destHub, err := connect(ctx, opts.Source, opts.Destination, opts.Logf, p)
+	if err != nil {
+		return nil, err
+	}
+	overwrite := opts.Overwrite
+	if len(overwrite) == 0 {
+		overwrite = image.OverwriteAlways
+	}
+	pr := &image.Promote{
+		SrcRegistry:  opts.Source.URL,
+		SrcImage:     opts.SourceImage,
+		SrcImageTag:  opts.SourceTag,
+		DestRegistry: opts.Destination.URL,
+		DestImage:    opts.DestinationImage,
+		DestImageTag: opts.DestinationTag,
+		Overwrite:    overwrite,
+		Force:        opts.Force,
+		Progress:     p,
+		Promoted:     promoted,
+	}
+	r := report.New()
+	err = pr.Push(ctx, srcHub, destHub)
+	if closeErr := closeBackends(srcHub, destHub); err == nil {
+		err = closeErr
+	}
+	if saveErr := promoted.Save(); err == nil {
+		err = saveErr
+	}
+	r.Add(pr.Result)
+	r.BytesTransferred = pr.Transferred
+	r.Finish()
+	return r, err
+}
+
+//Tags promotes selected image tags and prunes stale destination tags. Cancelling ctx aborts requests in flight.
+//Report describes every tag also when error is returned, unless registries could not be connected.
+//Invalid selectors are reported before registries are connected.
+//Error is ErrNoTagsSelected when selectors didn't match any tags and *FailedError when some tags failed to push
+func Tags(ctx context.Context, opts TagsOptions) (*report.Report, error) {
+	p := orDiscard(opts.Progress)
+	th := opts.tagPush(p)
+	if err := th.Validate(); err != nil {
+		return nil, err
+	}
+	promoted, err := loadDigestMap(opts.DigestMap)
+	if err != nil {
+		return nil, err
+	}
+	th.Promoted = promoted
+	srcHub, destHub, err := connect(ctx, opts.Source, opts.Destination, opts.Logf, p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := th.Push(ctx, srcHub, destHub)
+	if closeErr := closeBackends(srcHub, destHub); err == nil {
+		err = closeErr
+	}
+	if saveErr := promoted.Save(); err == nil {
+		err = saveErr
+	}
+	return r, err
+}
+
+//Validate checks tag selectors and destination tag template without connecting registries
+func (opts TagsOptions) Validate() error {
+	return opts.tagPush(progress.Discard).Validate()
+}
+
+func (opts TagsOptions) tagPush(p progress.Progress) *tags.TagPush {
+	overwrite := opts.Overwrite
+	if len(overwrite) == 0 {
+		overwrite = image.OverwriteAlways
+	}
+	return &tags.TagPush{
+		SrcRegistry:      opts.Source.URL,
+		SrcImage:         opts.SourceImage,
+		DestRegistry:     opts.Destination.URL,
+		DestImage:        opts.DestinationImage,
+		TagRegexp:        opts.TagRegexp,
+		TagExcludeRegexp: opts.TagExcludeRegexp,
+		TagSemver:        opts.TagSemver,
+		Latest:           opts.Latest,
+		TagsFrom:         opts.TagsFrom,
+		NewerThan:        opts.NewerThan,
+		OlderThan:        opts.OlderThan,
+		Labels:           opts.Labels,
+		Overwrite:        overwrite,
+		Force:            opts.Force,
+		DestTagTemplate:  opts.DestTagTemplate,
+		Prune:            opts.Prune,
+		MaxPrune:         opts.MaxPrune,
+		DryRun:           opts.DryRun,
+		Progress:         p,
+	}
+}
+
+type connectionResult struct {
+	hub backend.Backend
+	err error
+}
+
+//connect establishes connections to both registries in parallel. Backends are closed by closeBackends
+func connect(ctx context.Context, src Registry, dest Registry, logf func(format string, args ...interface{}), p progress.Progress) (backend.Backend, backend.Backend, error) {
+	srcResult := make(chan connectionResult, 1)
+	go func() {
+		hub, err := open(ctx, src, logf)
+		srcResult <- connectionResult{hub: hub, err: err}
+	}()
+	destHub, destErr := open(ctx, dest, logf)
+	s := <-srcResult
+	if s.err != nil || destErr != nil {
+		closeBackends(s.hub, destHub)
+	}
+	if s.err != nil {
+		return nil, nil, &ConnectionError{URL: src.URL, Err: s.err}
+	}
+	if destErr != nil {
+		return nil, nil, &ConnectionError{URL: dest.URL, Err: destErr}
+	}
+	p.Event(progress.Event{Type: progress.EventConnected, Registry: src.URL})
+	p.Event(progress.Event{Type: progress.EventConnected, Registry: dest.URL})
+	return s.hub, destHub, nil
+}
+
+//open connects registry, or opens OCI image layout directory or tarball of oci:/path and oci-archive:/path URLs
+func open(ctx context.Context, r Registry, logf func(format string, args ...interface{})) (backend.Backend, error) {
+	if archive.IsLayout(r.URL) {
+		return archive.OpenBackend(r.URL)
+	}
+	hub, err := connection.ConnectContext(ctx, r.URL, r.Username, r.Password, r.Insecure, logf)
+	if err != nil {
+		return nil, err
+	}
+	return backend.NewRegistry(hub), nil
+}
+
+//loadDigestMap reads digest map file, nil map remembers nothing when no file is given
+func loadDigestMap(path string) (*image.DigestMap, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return image.LoadDigestMap(path)
+}
+
+//closeBackends closes backends of OCI layouts, so changed OCI layout tarball is written. Returns error of the first failed backend
+func closeBackends(hubs ...backend.Backend) error {
+	var err error
+	for _, hub := range hubs {
+		if c, ok := hub.(io.Closer); ok {
+			if closeErr := c.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func orDiscard(p progress.Progress) progress.Progress {
+	if p == nil {
+		return progress.Discard
+	}
+	return p
+}
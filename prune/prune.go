@@ -0,0 +1,176 @@
+package prune
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/progress"
+)
+
+//DefaultMaxPrune is the number of destination tags which may be pruned by a single run unless specified otherwise
+const DefaultMaxPrune = 10
+
+//Stale returns destination tags which no longer exist on the source
+func Stale(srcTags []string, destTags []string) []string {
+	exists := make(map[string]bool)
+	for _, tag := range srcTags {
+		exists[tag] = true
+	}
+	stale := make([]string, 0)
+	for _, tag := range destTags {
+		if !exists[tag] {
+			stale = append(stale, tag)
+		}
+	}
+	return stale
+}
+
+//CheckLimit refuses pruning more than maxPrune tags, so broken source listing can not wipe destination. Negative maxPrune disables the limit
+func CheckLimit(stale []string, maxPrune int) error {
+	if maxPrune >= 0 && len(stale) > maxPrune {
+		return fmt.Errorf("%d stale tags exceed prune limit of %d, nothing is pruned", len(stale), maxPrune)
+	}
+	return nil
+}
+
+type digestResult struct {
+	tag    string
+	digest digest.Digest
+	err    error
+}
+
+//Deletion describes outcome of a single stale tag
+type Deletion struct {
+	Tag    string
+	Digest digest.Digest
+	//Pruned is set when tag was deleted, or would be deleted by dry run
+	Pruned bool
+	//SharedWith is kept tag pointing to the same manifest, stale tag is not deleted then
+	SharedWith string
+	Err        error
+}
+
+//Prune deletes stale destination tags and returns number of pruned tags. Only reports what would be pruned when dryRun is set.
+//Deleting a manifest removes every tag pointing to it, so stale tags sharing manifest with kept tags are not deleted
+func Prune(destHub backend.Backend, destImage string, destTags []string, stale []string, maxPrune int, dryRun bool, p progress.Progress) (int, error) {
+	deletions, err := Delete(destHub, destImage, destTags, stale, maxPrune, dryRun, p)
+	var pruned int
+	for _, d := range deletions {
+		if d.Pruned {
+			pruned++
+		}
+	}
+	return pruned, err
+}
+
+//Delete deletes stale destination tags the same way Prune does and returns outcome of every stale tag in tag order
+func Delete(destHub backend.Backend, destImage string, destTags []string, stale []string, maxPrune int, dryRun bool, p progress.Progress) ([]*Deletion, error) {
+	if err := CheckLimit(stale, maxPrune); err != nil {
+		return nil, err
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	digests, err := ManifestDigests(destHub, destImage, destTags)
+	if err != nil {
+		return nil, err
+	}
+	isStale := make(map[string]bool)
+	for _, tag := range stale {
+		isStale[tag] = true
+	}
+	kept := make(map[digest.Digest]string)
+	for _, tag := range destTags {
+		if !isStale[tag] {
+			kept[digests[tag]] = tag
+		}
+	}
+	sorted := make([]string, len(stale))
+	copy(sorted, stale)
+	sort.Strings(sorted)
+	deleted := make(map[digest.Digest]bool)
+	deletions := make([]*Deletion, 0, len(sorted))
+	var failed int
+	for _, tag := range sorted {
+		d := &Deletion{Tag: tag, Digest: digests[tag]}
+		deletions = append(deletions, d)
+		if keptTag, ok := kept[d.Digest]; ok {
+			p.Printf("Keeping tag %s:%s, it shares image manifest with tag %s\n", destImage, tag, keptTag)
+			d.SharedWith = keptTag
+			continue
+		}
+		if dryRun {
+			p.Printf("Would delete tag %s:%s\n", destImage, tag)
+			d.Pruned = true
+			continue
+		}
+		if !deleted[d.Digest] {
+			if err := deleteManifest(destHub, destImage, tag, d.Digest); err != nil {
+				p.Printf("Failed to delete tag %s:%s. Error: %s\n", destImage, tag, err.Error())
+				d.Err = err
+				failed++
+				continue
+			}
+			deleted[d.Digest] = true
+		}
+		p.Printf("Deleted tag %s:%s\n", destImage, tag)
+		d.Pruned = true
+	}
+	if failed > 0 {
+		return deletions, fmt.Errorf("failed to delete %d tags", failed)
+	}
+	return deletions, nil
+}
+
+//deleteManifest deletes manifest tag points to. Missing manifest counts as deleted only when the tag is gone as well,
+//otherwise tag points to another manifest than the one resolved and it is still there
+func deleteManifest(destHub backend.Backend, destImage string, tag string, d digest.Digest) error {
+	err := destHub.DeleteManifest(destImage, d)
+	if !image.IsNotFound(err) {
+		return err
+	}
+	current, headErr := destHub.ManifestDigest(destImage, tag)
+	if image.IsNotFound(headErr) {
+		//Manifest already removed by another run
+		return nil
+	}
+	if headErr != nil {
+		return fmt.Errorf("manifest %s not found and tag can not be checked: %v", d, headErr)
+	}
+	return fmt.Errorf("registry did not find manifest %s, tag still resolves to %s", d, current)
+}
+
+//ManifestDigests resolves digest of stored manifest of every destination tag using HEAD requests
+func ManifestDigests(destHub backend.Backend, destImage string, tags []string) (map[string]digest.Digest, error) {
+	digestQueue := tunny.NewFunc(5, func(payload interface{}) interface{} {
+		tag := payload.(string)
+		d, err := destHub.ManifestDigest(destImage, tag)
+		return &digestResult{tag: tag, digest: d, err: err}
+	})
+	defer digestQueue.Close()
+
+	resultChannel := make(chan *digestResult)
+	for _, tag := range tags {
+		go func(tag string) {
+			resultChannel <- digestQueue.Process(tag).(*digestResult)
+		}(tag)
+	}
+	digests := make(map[string]digest.Digest)
+	var err error
+	for range tags {
+		res := <-resultChannel
+		if res.err != nil {
+			err = fmt.Errorf("cannot resolve manifest of destination tag %s:%s: %v", destImage, res.tag, res.err)
+			continue
+		}
+		digests[res.tag] = res.digest
+	}
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
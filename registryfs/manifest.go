@@ -0,0 +1,61 @@
+package registryfs
+
+import (
+	"encoding/json"
+
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+)
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+)
+
+//storedManifestContent returns manifest bytes the way registry keeps them. Schema1 manifests are stored without signatures
+func storedManifestContent(mediaType string, payload []byte) ([]byte, error) {
+	var versioned struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(payload, &versioned); err != nil {
+		return nil, err
+	}
+	if versioned.SchemaVersion != 1 && mediaType != manifestV1.MediaTypeSignedManifest && mediaType != manifestV1.MediaTypeManifest {
+		return payload, nil
+	}
+	var sm manifestV1.SignedManifest
+	if err := sm.UnmarshalJSON(payload); err != nil {
+		return nil, err
+	}
+	return sm.Canonical, nil
+}
+
+//servedManifestContent detects media type of stored manifest and signs schema1 manifests
+func servedManifestContent(content []byte, key libtrust.PrivateKey) (string, []byte, error) {
+	var m struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &m); err != nil {
+		return "", nil, err
+	}
+	switch {
+	case m.SchemaVersion == 1:
+		jsig, err := libtrust.NewJSONSignature(content)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := jsig.Sign(key); err != nil {
+			return "", nil, err
+		}
+		signed, err := jsig.PrettySignature("signatures")
+		return manifestV1.MediaTypeSignedManifest, signed, err
+	case len(m.MediaType) > 0:
+		return m.MediaType, content, nil
+	case m.Manifests != nil:
+		return mediaTypeOCIIndex, content, nil
+	default:
+		return mediaTypeOCIManifest, content, nil
+	}
+}
@@ -0,0 +1,302 @@
+package registryfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/filesystem"
+	"github.com/docker/libtrust"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/logging"
+)
+
+//Prefix marks destination which is a registry storage directory instead of a Registry server
+const Prefix = "registry-fs:"
+
+const storagePathRoot = "/docker/registry/v2"
+
+//IsRegistryFS reports whether registry URL points to registry storage directory
+func IsRegistryFS(url string) bool {
+	return strings.HasPrefix(url, Prefix)
+}
+
+//Storage writes blobs, repository links and tag links straight into distribution registry on-disk layout
+type Storage struct {
+	root   string
+	driver storagedriver.StorageDriver
+	ctx    context.Context
+	key    libtrust.PrivateKey
+}
+
+//New creates storage for registry root directory, e.g. /var/lib/registry
+func New(root string) (*Storage, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{
+		root:   abs,
+		driver: filesystem.New(filesystem.DriverParameters{RootDirectory: abs, MaxThreads: 100}),
+		ctx:    context.Background(),
+		key:    key,
+	}, nil
+}
+
+//NewRegistry returns Registry client backed by registry storage directory. All Registry client operations are served from the filesystem
+func NewRegistry(url string) (*registry.Registry, error) {
+	storage, err := New(strings.TrimPrefix(url, Prefix))
+	if err != nil {
+		return nil, err
+	}
+	return &registry.Registry{
+		URL: Prefix + storage.root,
+		Client: &http.Client{
+			Transport: &registry.ErrorTransport{
+				Transport: &transport{storage: storage},
+			},
+		},
+		Logf: logging.Registry(Prefix + storage.root),
+	}, nil
+}
+
+func blobDataPath(d digest.Digest) string {
+	return path.Join(storagePathRoot, "blobs", string(d.Algorithm()), d.Hex()[:2], d.Hex(), "data")
+}
+
+func repositoryPath(repository string) string {
+	return path.Join(storagePathRoot, "repositories", repository)
+}
+
+func layerLinkPath(repository string, d digest.Digest) string {
+	return path.Join(repositoryPath(repository), "_layers", string(d.Algorithm()), d.Hex(), "link")
+}
+
+func uploadDataPath(repository string, id string) string {
+	return path.Join(repositoryPath(repository), "_uploads", id, "data")
+}
+
+func manifestRevisionLinkPath(repository string, d digest.Digest) string {
+	return path.Join(repositoryPath(repository), "_manifests", "revisions", string(d.Algorithm()), d.Hex(), "link")
+}
+
+func tagsPath(repository string) string {
+	return path.Join(repositoryPath(repository), "_manifests", "tags")
+}
+
+func tagCurrentLinkPath(repository string, tag string) string {
+	return path.Join(tagsPath(repository), tag, "current", "link")
+}
+
+func tagIndexLinkPath(repository string, tag string, d digest.Digest) string {
+	return path.Join(tagsPath(repository), tag, "index", string(d.Algorithm()), d.Hex(), "link")
+}
+
+func (s *Storage) readLink(p string) (digest.Digest, error) {
+	content, err := s.driver.GetContent(s.ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return digest.ParseDigest(strings.TrimSpace(string(content)))
+}
+
+func (s *Storage) writeLink(p string, d digest.Digest) error {
+	return s.driver.PutContent(s.ctx, p, []byte(d.String()))
+}
+
+//BlobSize returns size of blob linked into repository
+func (s *Storage) BlobSize(repository string, d digest.Digest) (int64, error) {
+	if _, err := s.readLink(layerLinkPath(repository, d)); err != nil {
+		return 0, err
+	}
+	fi, err := s.driver.Stat(s.ctx, blobDataPath(d))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+//ReadBlob opens blob linked into repository
+func (s *Storage) ReadBlob(repository string, d digest.Digest) (io.ReadCloser, int64, error) {
+	size, err := s.BlobSize(repository, d)
+	if err != nil {
+		return nil, 0, err
+	}
+	rd, err := s.driver.Reader(s.ctx, blobDataPath(d), 0)
+	return rd, size, err
+}
+
+//MountBlob links blob existing in another repository. Returns false when source repository does not hold the blob
+func (s *Storage) MountBlob(repository string, from string, d digest.Digest) (bool, error) {
+	if _, err := s.BlobSize(from, d); err != nil {
+		return false, nil
+	}
+	return true, s.writeLink(layerLinkPath(repository, d), d)
+}
+
+//PutBlob verifies content digest, stores it in blob store and links it into repository
+func (s *Storage) PutBlob(repository string, id string, d digest.Digest, content io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	uploadPath := uploadDataPath(repository, id)
+	defer s.driver.Delete(s.ctx, path.Dir(uploadPath))
+	if _, err := s.driver.Stat(s.ctx, blobDataPath(d)); err == nil {
+		//Blob already exists in blob store, content only has to be drained
+		verifier, _ := digest.NewDigestVerifier(d)
+		if _, err := io.Copy(verifier, content); err != nil {
+			return err
+		}
+		if !verifier.Verified() {
+			return fmt.Errorf("blob %s content does not match its digest", d)
+		}
+		return s.writeLink(layerLinkPath(repository, d), d)
+	}
+	w, err := s.driver.Writer(s.ctx, uploadPath, false)
+	if err != nil {
+		return err
+	}
+	verifier, _ := digest.NewDigestVerifier(d)
+	if _, err := io.Copy(io.MultiWriter(w, verifier), content); err != nil {
+		w.Cancel()
+		return err
+	}
+	if !verifier.Verified() {
+		w.Cancel()
+		return fmt.Errorf("blob %s content does not match its digest", d)
+	}
+	if err := w.Commit(); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := s.driver.Move(s.ctx, uploadPath, blobDataPath(d)); err != nil {
+		return err
+	}
+	return s.writeLink(layerLinkPath(repository, d), d)
+}
+
+//CancelUpload removes data of abandoned upload
+func (s *Storage) CancelUpload(repository string, id string) error {
+	return s.driver.Delete(s.ctx, path.Dir(uploadDataPath(repository, id)))
+}
+
+//PutManifest stores manifest revision and points tag to it. Reference can be a digest, then no tag is updated
+func (s *Storage) PutManifest(repository string, reference string, mediaType string, payload []byte) (digest.Digest, error) {
+	content, err := storedManifestContent(mediaType, payload)
+	if err != nil {
+		return "", err
+	}
+	d := digest.FromBytes(content)
+	if err := s.driver.PutContent(s.ctx, blobDataPath(d), content); err != nil {
+		return "", err
+	}
+	if err := s.writeLink(manifestRevisionLinkPath(repository, d), d); err != nil {
+		return "", err
+	}
+	if _, err := digest.ParseDigest(reference); err == nil {
+		if reference != d.String() {
+			return "", fmt.Errorf("manifest content does not match digest %s", reference)
+		}
+		return d, nil
+	}
+	if err := s.writeLink(tagCurrentLinkPath(repository, reference), d); err != nil {
+		return "", err
+	}
+	return d, s.writeLink(tagIndexLinkPath(repository, reference, d), d)
+}
+
+//ManifestDigest resolves tag or digest into manifest revision digest
+func (s *Storage) ManifestDigest(repository string, reference string) (digest.Digest, error) {
+	if d, err := digest.ParseDigest(reference); err == nil {
+		if _, err := s.readLink(manifestRevisionLinkPath(repository, d)); err != nil {
+			return "", err
+		}
+		return d, nil
+	}
+	return s.readLink(tagCurrentLinkPath(repository, reference))
+}
+
+//Manifest returns manifest content together with its media type. Schema1 manifests are signed on the fly like registry does
+func (s *Storage) Manifest(repository string, reference string) (digest.Digest, string, []byte, error) {
+	d, err := s.ManifestDigest(repository, reference)
+	if err != nil {
+		return "", "", nil, err
+	}
+	content, err := s.driver.GetContent(s.ctx, blobDataPath(d))
+	if err != nil {
+		return "", "", nil, err
+	}
+	mediaType, payload, err := servedManifestContent(content, s.key)
+	return d, mediaType, payload, err
+}
+
+//Tags lists repository tags
+func (s *Storage) Tags(repository string) ([]string, error) {
+	entries, err := s.driver.List(s.ctx, tagsPath(repository))
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(entries))
+	for _, e := range entries {
+		tags = append(tags, path.Base(e))
+	}
+	return tags, nil
+}
+
+//DeleteManifest removes manifest revision together with all tags pointing to it
+func (s *Storage) DeleteManifest(repository string, d digest.Digest) error {
+	if _, err := s.readLink(manifestRevisionLinkPath(repository, d)); err != nil {
+		return err
+	}
+	tags, _ := s.Tags(repository)
+	for _, tag := range tags {
+		if current, err := s.readLink(tagCurrentLinkPath(repository, tag)); err == nil && current == d {
+			if err := s.driver.Delete(s.ctx, path.Join(tagsPath(repository), tag)); err != nil {
+				return err
+			}
+		}
+	}
+	return s.driver.Delete(s.ctx, path.Dir(manifestRevisionLinkPath(repository, d)))
+}
+
+//Repositories lists repositories holding at least one manifest
+func (s *Storage) Repositories() ([]string, error) {
+	repositories := make([]string, 0)
+	root := path.Join(storagePathRoot, "repositories")
+	err := s.walkRepositories(root, &repositories)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return repositories, nil
+	}
+	return repositories, err
+}
+
+func (s *Storage) walkRepositories(dir string, repositories *[]string) error {
+	entries, err := s.driver.List(s.ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch path.Base(e) {
+		case "_manifests":
+			*repositories = append(*repositories, strings.TrimPrefix(dir, path.Join(storagePathRoot, "repositories")+"/"))
+		case "_layers", "_uploads":
+		default:
+			if err := s.walkRepositories(e, repositories); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
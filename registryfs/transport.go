@@ -0,0 +1,198 @@
+package registryfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/uuid"
+)
+
+//transport serves Registry API requests issued by Registry client straight from registry storage directory
+type transport struct {
+	storage *Storage
+}
+
+//RoundTrip routes /v2/ API request to storage operation
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	p := strings.TrimPrefix(req.URL.Path, t.storage.root)
+	if !strings.HasPrefix(p, "/v2/") {
+		return response(req, http.StatusNotFound, nil, nil), nil
+	}
+	p = strings.TrimPrefix(p, "/v2/")
+	switch {
+	case p == "":
+		return response(req, http.StatusOK, nil, nil), nil
+	case p == "_catalog":
+		repositories, err := t.storage.Repositories()
+		if err != nil {
+			return nil, err
+		}
+		return jsonResponse(req, map[string][]string{"repositories": repositories})
+	}
+	for _, route := range []string{"/blobs/uploads/", "/blobs/", "/manifests/", "/tags/list"} {
+		i := strings.LastIndex(p, route)
+		if i <= 0 {
+			continue
+		}
+		repository, reference := p[:i], p[i+len(route):]
+		switch route {
+		case "/blobs/uploads/":
+			return t.upload(req, repository, reference)
+		case "/blobs/":
+			return t.blob(req, repository, reference)
+		case "/manifests/":
+			return t.manifest(req, repository, reference)
+		default:
+			tags, err := t.storage.Tags(repository)
+			if err != nil {
+				return response(req, http.StatusNotFound, nil, nil), nil
+			}
+			return jsonResponse(req, map[string]interface{}{"name": repository, "tags": tags})
+		}
+	}
+	return response(req, http.StatusNotFound, nil, nil), nil
+}
+
+func (t *transport) upload(req *http.Request, repository string, id string) (*http.Response, error) {
+	q := req.URL.Query()
+	switch req.Method {
+	case "POST":
+		if mount := q.Get("mount"); len(mount) > 0 {
+			d, err := digest.ParseDigest(mount)
+			if err != nil {
+				return response(req, http.StatusBadRequest, nil, nil), nil
+			}
+			mounted, err := t.storage.MountBlob(repository, q.Get("from"), d)
+			if err != nil {
+				return nil, err
+			}
+			if mounted {
+				return response(req, http.StatusCreated, http.Header{"Docker-Content-Digest": {d.String()}}, nil), nil
+			}
+		}
+		location := "/v2/" + repository + "/blobs/uploads/" + uuid.Generate().String()
+		return response(req, http.StatusAccepted, http.Header{"Location": {location}}, nil), nil
+	case "PUT":
+		d, err := digest.ParseDigest(q.Get("digest"))
+		if err != nil {
+			return response(req, http.StatusBadRequest, nil, []byte(err.Error())), nil
+		}
+		body := req.Body
+		if body == nil {
+			body = ioutil.NopCloser(bytes.NewReader(nil))
+		}
+		if err := t.storage.PutBlob(repository, id, d, body); err != nil {
+			return response(req, http.StatusBadRequest, nil, []byte(err.Error())), nil
+		}
+		return response(req, http.StatusCreated, http.Header{"Docker-Content-Digest": {d.String()}}, nil), nil
+	case "DELETE":
+		t.storage.CancelUpload(repository, id)
+		return response(req, http.StatusNoContent, nil, nil), nil
+	}
+	return response(req, http.StatusMethodNotAllowed, nil, nil), nil
+}
+
+func (t *transport) blob(req *http.Request, repository string, reference string) (*http.Response, error) {
+	d, err := digest.ParseDigest(reference)
+	if err != nil {
+		return response(req, http.StatusBadRequest, nil, nil), nil
+	}
+	switch req.Method {
+	case "HEAD":
+		size, err := t.storage.BlobSize(repository, d)
+		if err != nil {
+			return response(req, http.StatusNotFound, nil, nil), nil
+		}
+		resp := response(req, http.StatusOK, http.Header{"Docker-Content-Digest": {d.String()}}, nil)
+		resp.ContentLength = size
+		resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		return resp, nil
+	case "GET":
+		rd, size, err := t.storage.ReadBlob(repository, d)
+		if err != nil {
+			return response(req, http.StatusNotFound, nil, nil), nil
+		}
+		resp := response(req, http.StatusOK, http.Header{"Docker-Content-Digest": {d.String()}}, nil)
+		resp.Body = rd
+		resp.ContentLength = size
+		return resp, nil
+	}
+	return response(req, http.StatusMethodNotAllowed, nil, nil), nil
+}
+
+func (t *transport) manifest(req *http.Request, repository string, reference string) (*http.Response, error) {
+	switch req.Method {
+	case "HEAD", "GET":
+		d, mediaType, payload, err := t.storage.Manifest(repository, reference)
+		if err != nil {
+			return response(req, http.StatusNotFound, nil, nil), nil
+		}
+		header := http.Header{
+			"Docker-Content-Digest": {d.String()},
+			"Content-Type":          {mediaType},
+		}
+		if req.Method == "HEAD" {
+			resp := response(req, http.StatusOK, header, nil)
+			resp.ContentLength = int64(len(payload))
+			return resp, nil
+		}
+		return response(req, http.StatusOK, header, payload), nil
+	case "PUT":
+		if req.Body == nil {
+			return response(req, http.StatusBadRequest, nil, nil), nil
+		}
+		payload, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		d, err := t.storage.PutManifest(repository, reference, req.Header.Get("Content-Type"), payload)
+		if err != nil {
+			return response(req, http.StatusBadRequest, nil, []byte(err.Error())), nil
+		}
+		return response(req, http.StatusCreated, http.Header{"Docker-Content-Digest": {d.String()}}, nil), nil
+	case "DELETE":
+		d, err := digest.ParseDigest(reference)
+		if err != nil {
+			return response(req, http.StatusBadRequest, nil, nil), nil
+		}
+		if err := t.storage.DeleteManifest(repository, d); err != nil {
+			return response(req, http.StatusNotFound, nil, nil), nil
+		}
+		return response(req, http.StatusAccepted, nil, nil), nil
+	}
+	return response(req, http.StatusMethodNotAllowed, nil, nil), nil
+}
+
+func response(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func jsonResponse(req *http.Request, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return response(req, http.StatusOK, http.Header{"Content-Type": {"application/json"}}, body), nil
+}
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/archive"
+	"github.com/vbaksa/promoter/registryfs"
+)
+
+//Output formats
+const (
+	//FormatText prints human readable progress only
+	FormatText = "text"
+	//FormatJSON prints report as JSON to stdout, human readable progress goes to stderr
+	FormatJSON = "json"
+)
+
+//Tag statuses
+const (
+	TagPushed    = "pushed"
+	TagUnchanged = "unchanged"
+	TagFailed    = "failed"
+	//TagDeleted and TagKept describe tags of retention run
+	TagDeleted = "deleted"
+	TagKept    = "kept"
+	//TagPlanned is tag which would be changed, reported by dry run
+	TagPlanned = "planned"
+)
+
+//Layer statuses
+const (
+	LayerUploaded = "uploaded"
+	LayerSkipped  = "skipped"
+	LayerMounted  = "mounted"
+	LayerFailed   = "failed"
+)
+
+//Report describes result of push, tags and retain commands
+type Report struct {
+	Tags             []*Tag    `json:"tags"`
+	Pushed           int       `json:"pushed"`
+	Unchanged        int       `json:"unchanged"`
+	Failed           int       `json:"failed"`
+	Deleted          int       `json:"deleted,omitempty"`
+	Kept             int       `json:"kept,omitempty"`
+	Planned          int       `json:"planned,omitempty"`
+	BytesTransferred int64     `json:"bytesTransferred"`
+	Started          time.Time `json:"started"`
+	DurationSeconds  float64   `json:"durationSeconds"`
+}
+
+//Tag describes result of a single tag promotion. Digests are only known when manifests were downloaded, destination is unknown when source manifest could not be downloaded
+type Tag struct {
+	Source            string        `json:"source"`
+	SourceDigest      digest.Digest `json:"sourceDigest,omitempty"`
+	Destination       string        `json:"destination,omitempty"`
+	DestinationDigest digest.Digest `json:"destinationDigest,omitempty"`
+	Status            string        `json:"status"`
+	Layers            []*Layer      `json:"layers,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	//DurationSeconds is time tag took to finish, counted from fetching its source manifest. Tags skipped or failed before that are counted from the start of the run
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+//Layer describes how image layer got to Destination Registry. Size is only known for transferred layers
+type Layer struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size,omitempty"`
+	Status string        `json:"status"`
+	Error  string        `json:"error,omitempty"`
+}
+
+//Reference returns image reference of report without Registry protocol
+func Reference(registry string, image string, tag string) string {
+	if registryfs.IsRegistryFS(registry) || archive.IsLayout(registry) {
+		return registry + ":" + image + ":" + tag
+	}
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	return registry + "/" + image + ":" + tag
+}
+
+//New starts report
+func New() *Report {
+	return &Report{Tags: make([]*Tag, 0), Started: time.Now()}
+}
+
+//Add records result of tag promotion. Tag without duration is finished when it is added
+func (r *Report) Add(t *Tag) {
+	if t.DurationSeconds == 0 {
+		t.DurationSeconds = time.Since(r.Started).Seconds()
+	}
+	r.Tags = append(r.Tags, t)
+}
+
+//Fail sets tag status to failed
+func (t *Tag) Fail(err error) {
+	t.Status = TagFailed
+	t.Error = err.Error()
+}
+
+//Finish counts tag results and sets duration
+func (r *Report) Finish() {
+	r.Pushed, r.Unchanged, r.Failed, r.Deleted, r.Kept, r.Planned = 0, 0, 0, 0, 0, 0
+	for _, t := range r.Tags {
+		switch t.Status {
+		case TagPushed:
+			r.Pushed++
+		case TagUnchanged:
+			r.Unchanged++
+		case TagFailed:
+			r.Failed++
+		case TagDeleted:
+			r.Deleted++
+		case TagKept:
+			r.Kept++
+		case TagPlanned:
+			r.Planned++
+		}
+	}
+	r.DurationSeconds = time.Since(r.Started).Seconds()
+}
+
+//Output writes report as JSON to Stdout and into report file
+type Output struct {
+	Format string
+	File   string
+	//Stdout receives JSON report, os.Stdout when nil
+	Stdout io.Writer
+}
+
+//ValidFormat reports whether output format is known
+func ValidFormat(format string) bool {
+	return format == "" || format == FormatText || format == FormatJSON
+}
+
+//Messages returns writer of human readable output. It is stderr when report is printed to stdout, so stdout holds JSON only
+func (o *Output) Messages() io.Writer {
+	if o.Format == FormatJSON {
+		return os.Stderr
+	}
+	return o.stdout()
+}
+
+func (o *Output) stdout() io.Writer {
+	if o.Stdout == nil {
+		return os.Stdout
+	}
+	return o.Stdout
+}
+
+//Write writes finished report. Nothing is written for text output without report file
+func (o *Output) Write(r *Report) error {
+	if o.Format != FormatJSON && len(o.File) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if len(o.File) > 0 {
+		if err := ioutil.WriteFile(o.File, data, 0644); err != nil {
+			return fmt.Errorf("cannot write report: %v", err)
+		}
+	}
+	if o.Format == FormatJSON {
+		_, err = o.stdout().Write(data)
+	}
+	return err
+}
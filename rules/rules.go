@@ -0,0 +1,188 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/vbaksa/promoter/prune"
+	"github.com/vbaksa/promoter/registryfs"
+	"gopkg.in/yaml.v2"
+)
+
+//Rules holds promotion rules loaded from rules file
+type Rules struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+//Rule maps source repository and tag pattern to promotion destinations.
+//Schedule, Interval, Prune and MaxPrune are used by sync command only, Schedule takes precedence.
+//MaxPrune is prune.DefaultMaxPrune when not set, negative value disables the limit
+type Rule struct {
+	Source       Source        `yaml:"source"`
+	Destinations []Destination `yaml:"destinations"`
+	Schedule     string        `yaml:"schedule"`
+	Interval     string        `yaml:"interval"`
+	Prune        bool          `yaml:"prune"`
+	MaxPrune     *int          `yaml:"maxPrune"`
+
+	repository *regexp.Regexp
+	tag        *regexp.Regexp
+	schedule   cron.Schedule
+	interval   time.Duration
+}
+
+//Source describes Registry images are pulled from. Repository and Tag are regular expressions matched against the whole name
+type Source struct {
+	Registry   string `yaml:"registry"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Insecure   bool   `yaml:"insecure"`
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+}
+
+//Destination describes Registry matching images are promoted into. Source repository name is kept when Repository is empty
+type Destination struct {
+	Registry   string `yaml:"registry"`
+	Repository string `yaml:"repository"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Insecure   bool   `yaml:"insecure"`
+}
+
+//Load reads and validates rules file. Credentials may reference environment variables, e.g. ${REGISTRY_PASSWORD}
+func Load(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, err
+	}
+	return New(rules.Rules...)
+}
+
+//New validates rules and prepares them for matching
+func New(rules ...*Rule) (*Rules, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("no rules specified")
+	}
+	for i, r := range rules {
+		if err := r.init(); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return &Rules{Rules: rules}, nil
+}
+
+func (r *Rule) init() error {
+	if len(r.Source.Registry) == 0 {
+		return errors.New("source registry is missing")
+	}
+	if len(r.Destinations) == 0 {
+		return errors.New("no destinations specified")
+	}
+	var err error
+	if r.repository, err = compilePattern(r.Source.Repository); err != nil {
+		return fmt.Errorf("invalid repository pattern: %v", err)
+	}
+	if r.tag, err = compilePattern(r.Source.Tag); err != nil {
+		return fmt.Errorf("invalid tag pattern: %v", err)
+	}
+	if len(r.Schedule) > 0 {
+		if r.schedule, err = cron.ParseStandard(r.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %v", err)
+		}
+	}
+	if len(r.Interval) > 0 {
+		if r.interval, err = time.ParseDuration(r.Interval); err != nil {
+			return fmt.Errorf("invalid interval: %v", err)
+		}
+	}
+	_, literal := r.LiteralRepository()
+	r.Source.Registry = registryURL(r.Source.Registry)
+	r.Source.Username = os.ExpandEnv(r.Source.Username)
+	r.Source.Password = os.ExpandEnv(r.Source.Password)
+	for i := range r.Destinations {
+		d := &r.Destinations[i]
+		if len(d.Registry) == 0 {
+			return fmt.Errorf("destination %d registry is missing", i+1)
+		}
+		if r.Prune && len(d.Repository) > 0 && !literal {
+			return fmt.Errorf("destination %d repository receives several source repositories, it can not be pruned", i+1)
+		}
+		d.Registry = registryURL(d.Registry)
+		d.Username = os.ExpandEnv(d.Username)
+		d.Password = os.ExpandEnv(d.Password)
+	}
+	return nil
+}
+
+//PruneLimit returns the number of tags a single run may prune, negative value means no limit
+func (r *Rule) PruneLimit() int {
+	if r.MaxPrune == nil {
+		return prune.DefaultMaxPrune
+	}
+	return *r.MaxPrune
+}
+
+//Matches reports whether image should be promoted by the rule
+func (r *Rule) Matches(repository string, tag string) bool {
+	return r.repository.MatchString(repository) && r.tag.MatchString(tag)
+}
+
+//MatchesRepository reports whether repository is covered by the rule
+func (r *Rule) MatchesRepository(repository string) bool {
+	return r.repository.MatchString(repository)
+}
+
+//LiteralRepository returns repository name when pattern does not contain any regular expression, so Registry catalog is not needed
+func (r *Rule) LiteralRepository() (string, bool) {
+	re, err := regexp.Compile(r.Source.Repository)
+	if err != nil || len(r.Source.Repository) == 0 {
+		return "", false
+	}
+	return re.LiteralPrefix()
+}
+
+//Next returns time of the next scheduled run after t. Default interval is used when rule has neither schedule nor interval
+func (r *Rule) Next(t time.Time, defaultInterval time.Duration) time.Time {
+	if r.schedule != nil {
+		return r.schedule.Next(t)
+	}
+	if r.interval > 0 {
+		return t.Add(r.interval)
+	}
+	return t.Add(defaultInterval)
+}
+
+//DestRepository returns destination repository name for source repository
+func (d *Destination) DestRepository(repository string) string {
+	if len(d.Repository) > 0 {
+		return d.Repository
+	}
+	return repository
+}
+
+//compilePattern anchors pattern so it has to match the whole name. Empty pattern matches everything
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		pattern = ".*"
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+//registryURL adds HTTPS protocol when rule does not specify one
+func registryURL(registry string) string {
+	if registryfs.IsRegistryFS(registry) || strings.HasPrefix(registry, "http://") || strings.HasPrefix(registry, "https://") {
+		return strings.TrimSuffix(registry, "/")
+	}
+	return "https://" + strings.TrimSuffix(registry, "/")
+}
@@ -0,0 +1,310 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/logging"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/prune"
+	"github.com/vbaksa/promoter/rules"
+)
+
+//shutdownTimeout is time given to health requests in flight when sync stops
+const shutdownTimeout = 5 * time.Second
+
+//Sync holds scheduled synchronisation structure. Either RulesFile or Rules has to be provided
+type Sync struct {
+	RulesFile string
+	Rules     *rules.Rules
+	Interval  time.Duration
+	Listen    string
+
+	mappings []*mapping
+}
+
+//mapping keeps synchronisation state of a single rule between runs
+type mapping struct {
+	sync.Mutex
+	rule *rules.Rule
+	//synced remembers Source Image manifest digest last promoted to destination, keyed by destination image
+	synced    map[string]digest.Digest
+	completed bool
+	lastRun   time.Time
+	lastErr   error
+}
+
+type runStats struct {
+	promoted  int
+	unchanged int
+	pruned    int
+	failed    int
+}
+
+//SyncImages runs as a daemon promoting new and changed tags on schedule until ctx is cancelled. Failures are reported and retried on the next run.
+//On cancellation running promotions are finished and the rest of the run is skipped
+func (s *Sync) SyncImages(ctx context.Context) error {
+	if s.Rules == nil {
+		r, err := rules.Load(s.RulesFile)
+		if err != nil {
+			return fmt.Errorf("failed to load rules: %v", err)
+		}
+		s.Rules = r
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var schedules sync.WaitGroup
+	for _, r := range s.Rules.Rules {
+		m := &mapping{rule: r, synced: make(map[string]digest.Digest)}
+		s.mappings = append(s.mappings, m)
+		schedules.Add(1)
+		go func() {
+			defer schedules.Done()
+			s.schedule(ctx, m)
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	srv := &http.Server{Addr: s.Listen, Handler: mux}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	logrus.WithFields(logrus.Fields{"rules": len(s.mappings), "listen": s.Listen}).Info("Syncing rules, health endpoints listening")
+
+	var err error
+	select {
+	case err = <-listenErr:
+		err = fmt.Errorf("health endpoints stopped: %v", err)
+	case <-ctx.Done():
+		logrus.Info("Stopping sync")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancelShutdown()
+	}
+	cancel()
+	schedules.Wait()
+	return err
+}
+
+//handleHealth reports liveness together with the state of the last run of each rule
+func (s *Sync) handleHealth(w http.ResponseWriter, r *http.Request) {
+	for i, m := range s.mappings {
+		m.Lock()
+		status := "pending"
+		if m.completed {
+			status = "ok"
+			if m.lastErr != nil {
+				status = "error: " + m.lastErr.Error()
+			}
+		}
+		fmt.Fprintf(w, "rule %d %s/%s last run %s: %s\n", i+1, m.rule.Source.Registry, m.rule.Source.Repository, m.lastRun.Format(time.RFC3339), status)
+		m.Unlock()
+	}
+}
+
+//handleReady reports readiness once every rule completed its first run
+func (s *Sync) handleReady(w http.ResponseWriter, r *http.Request) {
+	for _, m := range s.mappings {
+		m.Lock()
+		completed := m.completed
+		m.Unlock()
+		if !completed {
+			http.Error(w, "initial sync in progress", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+//schedule runs rule until ctx is cancelled
+func (s *Sync) schedule(ctx context.Context, m *mapping) {
+	for {
+		started := time.Now()
+		stats, err := s.run(ctx, m)
+		m.Lock()
+		m.completed = true
+		m.lastRun = started
+		m.lastErr = err
+		m.Unlock()
+		entry := logging.Image(m.rule.Source.Registry, m.rule.Source.Repository)
+		if ctx.Err() != nil {
+			entry.Info("Sync stopped")
+			return
+		}
+		if err != nil {
+			entry.WithField("error", err.Error()).Error("Sync failed")
+		}
+		next := m.rule.Next(time.Now(), s.Interval)
+		entry.WithFields(logrus.Fields{
+			"promoted":  stats.promoted,
+			"unchanged": stats.unchanged,
+			"pruned":    stats.pruned,
+			"failed":    stats.failed,
+			"next":      next.Format(time.RFC3339),
+		}).Info("Sync done")
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+//run promotes every matching tag which is missing on destination or differs from the source
+func (s *Sync) run(ctx context.Context, m *mapping) (runStats, error) {
+	var stats runStats
+	src := m.rule.Source
+	srcRegistry, err := connection.Connect(src.Registry, src.Username, src.Password, src.Insecure)
+	if err != nil {
+		return stats, fmt.Errorf("cannot connect to Source Registry: %v", err)
+	}
+	srcHub := backend.NewRegistry(srcRegistry)
+	repositories, err := sourceRepositories(srcHub, m.rule)
+	if err != nil {
+		return stats, fmt.Errorf("cannot list Source Registry repositories: %v", err)
+	}
+	destHubs := make([]backend.Backend, len(m.rule.Destinations))
+	for i, d := range m.rule.Destinations {
+		destRegistry, err := connection.Connect(d.Registry, d.Username, d.Password, d.Insecure)
+		if err != nil {
+			return stats, fmt.Errorf("cannot connect to Destination Registry %s: %v", d.Registry, err)
+		}
+		destHubs[i] = backend.NewRegistry(destRegistry)
+	}
+	for _, repository := range repositories {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		tags, err := srcHub.Tags(repository)
+		if err != nil {
+			logging.Image(src.Registry, repository).WithField("error", err.Error()).Error("Failed to list tags")
+			stats.failed++
+			continue
+		}
+		for _, tag := range tags {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			if !m.rule.Matches(repository, tag) {
+				continue
+			}
+			for i, d := range m.rule.Destinations {
+				pr := &image.Promote{
+					SrcRegistry:  src.Registry,
+					SrcImage:     repository,
+					SrcImageTag:  tag,
+					DestRegistry: d.Registry,
+					DestImage:    d.DestRepository(repository),
+					DestImageTag: tag,
+				}
+				promoted, err := m.promote(pr, srcHub, destHubs[i])
+				switch {
+				case err != nil:
+					logging.Image(src.Registry, repository).WithFields(logrus.Fields{
+						logging.FieldTag: tag,
+						"destination":    d.Registry + "/" + pr.DestImage + ":" + tag,
+					}).WithField("error", err.Error()).Error("Failed to promote")
+					stats.failed++
+				case promoted:
+					stats.promoted++
+				default:
+					stats.unchanged++
+				}
+			}
+		}
+		if m.rule.Prune {
+			for i, d := range m.rule.Destinations {
+				pruned, err := m.prune(repository, tags, destHubs[i], d)
+				stats.pruned += pruned
+				if err != nil {
+					logging.Image(d.Registry, d.DestRepository(repository)).WithField("error", err.Error()).Error("Failed to prune")
+					stats.failed++
+				}
+			}
+		}
+	}
+	if stats.failed > 0 {
+		return stats, fmt.Errorf("%d promotions failed", stats.failed)
+	}
+	return stats, nil
+}
+
+//promote pushes image unless destination already holds the same image. Returns false when image was unchanged
+func (m *mapping) promote(pr *image.Promote, srcHub backend.Backend, destHub backend.Backend) (bool, error) {
+	key := pr.DestRegistry + "/" + pr.DestImage + ":" + pr.DestImageTag
+	srcDigest, err := srcHub.ManifestDigest(pr.SrcImage, pr.SrcImageTag)
+	if err != nil {
+		return false, err
+	}
+	if m.synced[key] == srcDigest {
+		return false, nil
+	}
+	//Destination manifest is re-signed, so digests differ. Compare image content after restart instead
+	srcManifest, err := srcHub.Manifest(pr.SrcImage, pr.SrcImageTag)
+	if err != nil {
+		return false, err
+	}
+	if destManifest, err := destHub.Manifest(pr.DestImage, pr.DestImageTag); err == nil && image.SameImage(srcManifest, destManifest) {
+		m.synced[key] = srcDigest
+		return false, nil
+	}
+	if err := pr.Push(context.Background(), srcHub, destHub); err != nil {
+		return false, err
+	}
+	m.synced[key] = srcDigest
+	return true, nil
+}
+
+//prune deletes destination tags matching the rule which no longer exist on the source
+func (m *mapping) prune(repository string, srcTags []string, destHub backend.Backend, d rules.Destination) (int, error) {
+	destImage := d.DestRepository(repository)
+	destTags, err := destHub.Tags(destImage)
+	if image.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	candidates := make([]string, 0)
+	for _, tag := range destTags {
+		if m.rule.Matches(repository, tag) {
+			candidates = append(candidates, tag)
+		}
+	}
+	stale := prune.Stale(srcTags, candidates)
+	pruned, err := prune.Prune(destHub, destImage, destTags, stale, m.rule.PruneLimit(), false, progress.Console{})
+	for _, tag := range stale {
+		delete(m.synced, d.Registry+"/"+destImage+":"+tag)
+	}
+	return pruned, err
+}
+
+func sourceRepositories(srcHub backend.Backend, rule *rules.Rule) ([]string, error) {
+	if repository, ok := rule.LiteralRepository(); ok {
+		return []string{repository}, nil
+	}
+	all, err := srcHub.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	repositories := make([]string, 0)
+	for _, repository := range all {
+		if rule.MatchesRepository(repository) {
+			repositories = append(repositories, repository)
+		}
+	}
+	return repositories, nil
+}
@@ -0,0 +1,128 @@
+package tags
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+)
+
+//imageFilter selects images by creation time and labels stored in image configuration
+type imageFilter struct {
+	newerThan time.Time
+	olderThan time.Time
+	labels    map[string]*string
+}
+
+//v1Image holds image configuration fields kept in schema1 History v1Compatibility
+type v1Image struct {
+	Created time.Time `json:"created"`
+	Config  struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+//newImageFilter parses image selectors. Returns nil filter when no selector is specified
+func (th *TagPush) newImageFilter(now time.Time) (*imageFilter, error) {
+	if len(th.NewerThan) == 0 && len(th.OlderThan) == 0 && len(th.Labels) == 0 {
+		return nil, nil
+	}
+	f := &imageFilter{labels: make(map[string]*string)}
+	var err error
+	if len(th.NewerThan) > 0 {
+		if f.newerThan, err = parseAge(th.NewerThan, now); err != nil {
+			return nil, fmt.Errorf("invalid newer than value: %v", err)
+		}
+	}
+	if len(th.OlderThan) > 0 {
+		if f.olderThan, err = parseAge(th.OlderThan, now); err != nil {
+			return nil, fmt.Errorf("invalid older than value: %v", err)
+		}
+	}
+	for _, l := range th.Labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid label selector %q", l)
+		}
+		if len(kv) == 1 {
+			f.labels[kv[0]] = nil
+			continue
+		}
+		value := kv[1]
+		f.labels[kv[0]] = &value
+	}
+	return f, nil
+}
+
+//parseAge accepts either a duration counted back from now, e.g. 720h, 30d or 2w, or a date, e.g. 2017-03-01 or RFC3339 time
+func parseAge(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return time.Time{}, err
+			}
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, errors.New("expected duration (e.g. 720h, 30d, 2w) or date (e.g. 2017-03-01)")
+	}
+	return now.Add(-d), nil
+}
+
+//matches reports whether image described by manifest satisfies all selectors. Top History entry holds configuration of the image itself
+func (f *imageFilter) matches(m *manifestV1.SignedManifest) (bool, error) {
+	if len(m.History) == 0 {
+		return false, errors.New("image manifest does not contain history")
+	}
+	var img v1Image
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &img); err != nil {
+		return false, fmt.Errorf("cannot parse image configuration: %v", err)
+	}
+	if !f.newerThan.IsZero() && !img.Created.After(f.newerThan) {
+		return false, nil
+	}
+	if !f.olderThan.IsZero() && !img.Created.Before(f.olderThan) {
+		return false, nil
+	}
+	for key, value := range f.labels {
+		actual, ok := img.Config.Labels[key]
+		if !ok || (value != nil && actual != *value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//filterManifests keeps manifests of images matching the filter. Manifests which failed to download are kept, so the failure is reported
+func (th *TagPush) filterManifests(manifests []manifestGetResult, f *imageFilter) []manifestGetResult {
+	filtered := make([]manifestGetResult, 0, len(manifests))
+	for _, m := range manifests {
+		if m.err != nil {
+			filtered = append(filtered, m)
+			continue
+		}
+		ok, err := f.matches(&m.manifest)
+		if err != nil {
+			th.Progress.Printf("Skipping tag %s. Error: %s \n", m.tag, err.Error())
+			continue
+		}
+		if ok {
+			filtered = append(filtered, m)
+		}
+	}
+	th.Progress.Printf("Image selectors matched %d of %d tags \n", len(filtered), len(manifests))
+	return filtered
+}
@@ -0,0 +1,138 @@
+package tags
+
+import (
+	"github.com/Jeffail/tunny"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/report"
+)
+
+//skipUnchanged drops tags whose destination manifest digest equals the source one, or digests remembered by Promoted from earlier promotion.
+//Only HEAD requests are issued, so no further work is done on unchanged tags. Digests of checked tags are kept for the rest of the push
+func (th *TagPush) skipUnchanged(srcHub backend.Backend, destHub backend.Backend, tags []string, t *tagTemplate, poolSize int) ([]string, int) {
+	th.digests = make(map[string]image.Digests)
+	if t.needsManifest() {
+		//Destination tags are not known before source manifests are downloaded
+		return tags, 0
+	}
+	th.Progress.Printf("Looking for unchanged tags...\n")
+	type unchangedCheck struct {
+		tag       string
+		digests   image.Digests
+		err       error
+		unchanged bool
+	}
+	unchangedQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
+		tag := payload.(string)
+		destTag, err := t.destTag(tag, nil)
+		if err != nil {
+			return unchangedCheck{tag: tag, err: err}
+		}
+		digests, err := image.HeadDigests(srcHub, th.SrcImage, tag, destHub, th.DestImage, destTag)
+		return unchangedCheck{
+			tag:       tag,
+			digests:   digests,
+			err:       err,
+			unchanged: err == nil && image.Unchanged(digests, th.Promoted, report.Reference(th.DestRegistry, th.DestImage, destTag)),
+		}
+	})
+	defer unchangedQueue.Close()
+
+	checkChannel := make(chan unchangedCheck)
+	for _, tag := range tags {
+		go func(tag string) {
+			checkChannel <- unchangedQueue.Process(tag).(unchangedCheck)
+		}(tag)
+	}
+	unchanged := make(map[string]bool)
+	for i := 0; i < len(tags); i++ {
+		res := <-checkChannel
+		unchanged[res.tag] = res.unchanged
+		if res.err == nil {
+			th.digests[res.tag] = res.digests
+		}
+	}
+	changed := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !unchanged[tag] {
+			changed = append(changed, tag)
+		}
+	}
+	for _, tag := range tags {
+		if unchanged[tag] {
+			destTag, _ := t.destTag(tag, nil)
+			th.result.Add(&report.Tag{
+				Source:      report.Reference(th.SrcRegistry, th.SrcImage, tag),
+				Destination: report.Reference(th.DestRegistry, th.DestImage, destTag),
+				Status:      report.TagUnchanged,
+			})
+		}
+	}
+	if skipped := len(tags) - len(changed); skipped > 0 {
+		th.Progress.Printf("Skipping %d unchanged tags \n", skipped)
+	}
+	return changed, len(tags) - len(changed)
+}
+
+type overwriteCheck struct {
+	manifest manifestGetResult
+	skip     bool
+	err      error
+}
+
+//checkOverwrite compares images of every tag with destination and applies overwrite policy before any layer is transferred.
+//Tags known by skipUnchanged not to exist on destination are not compared.
+//Returns tags to push, tags refused by the policy and number of tags skipped because destination already holds the same image
+func (th *TagPush) checkOverwrite(destHub backend.Backend, manifests []manifestGetResult, poolSize int) ([]manifestGetResult, []overwriteCheck, int) {
+	th.Progress.Printf("Checking existing destination tags...\n")
+	checkQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
+		m := payload.(manifestGetResult)
+		if m.err != nil {
+			return &overwriteCheck{manifest: m}
+		}
+		digests, known := th.digests[m.tag]
+		if known && len(digests.Destination) == 0 {
+			//Destination tag does not exist, nothing to compare
+			return &overwriteCheck{manifest: m}
+		}
+		skip, err := image.CheckOverwrite(destHub, th.DestImage, m.destTag, &m.manifest, th.Overwrite, th.Force)
+		if skip && known {
+			th.Promoted.Record(report.Reference(th.DestRegistry, th.DestImage, m.destTag), digests)
+		}
+		return &overwriteCheck{manifest: m, skip: skip, err: err}
+	})
+	defer checkQueue.Close()
+
+	checkChannel := make(chan *overwriteCheck)
+	for _, m := range manifests {
+		go func(m manifestGetResult) {
+			checkChannel <- checkQueue.Process(m).(*overwriteCheck)
+		}(m)
+	}
+	checkProgressBar := th.Progress.Stage(progress.StageOverwrite, int64(len(manifests)), progress.Count)
+	push := make([]manifestGetResult, 0, len(manifests))
+	refused := make([]overwriteCheck, 0)
+	var skipped int
+	for i := 0; i < len(manifests); i++ {
+		res := <-checkChannel
+		checkProgressBar.Add(1)
+		switch {
+		case res.err != nil:
+			refused = append(refused, *res)
+		case res.skip:
+			th.result.Add(th.tagResult(res.manifest, report.TagUnchanged))
+			skipped++
+		default:
+			push = append(push, res.manifest)
+		}
+	}
+	checkProgressBar.Finish()
+	if skipped > 0 {
+		th.Progress.Printf("Skipping %d tags already holding the same image \n", skipped)
+	}
+	if len(refused) > 0 {
+		th.Progress.Printf("Refusing to overwrite %d tags \n", len(refused))
+	}
+	return push, refused, skipped
+}
@@ -0,0 +1,71 @@
+package tags
+
+import (
+	"fmt"
+
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/prune"
+)
+
+//pruning holds destination tags listed before any tag is pushed
+type pruning struct {
+	destTags []string
+	stale    []string
+}
+
+//staleTags finds destination tags matching tag selectors which no longer exist on the source. Fails when prune limit is exceeded, so nothing is pushed either
+func (th *TagPush) staleTags(destHub backend.Backend, srcTags []string) (*pruning, error) {
+	destTags, err := destHub.Tags(th.DestImage)
+	if image.IsNotFound(err) {
+		return &pruning{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot list destination tags: %v", err)
+	}
+	candidates, err := th.pruneCandidates(destTags)
+	if err != nil {
+		return nil, err
+	}
+	stale := prune.Stale(srcTags, candidates)
+	if err := prune.CheckLimit(stale, th.MaxPrune); err != nil {
+		return nil, err
+	}
+	th.Progress.Printf("Found %d stale destination tags \n", len(stale))
+	return &pruning{destTags: destTags, stale: stale}, nil
+}
+
+//pruneCandidates applies tag name selectors to destination tags. Tags file and latest versions only limit what is pushed, so they are not applied
+func (th *TagPush) pruneCandidates(tags []string) ([]string, error) {
+	var err error
+	if len(th.TagRegexp) > 0 {
+		if tags, err = filterByVersionSelector(tags, th.TagRegexp); err != nil {
+			return nil, err
+		}
+	}
+	if len(th.TagExcludeRegexp) > 0 {
+		if tags, err = excludeByRegexp(tags, th.TagExcludeRegexp); err != nil {
+			return nil, err
+		}
+	}
+	if len(th.TagSemver) > 0 {
+		if tags, err = filterBySemver(tags, th.TagSemver); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+//pruneStale deletes stale destination tags, or only reports them on dry run
+func (th *TagPush) pruneStale(destHub backend.Backend, p *pruning) error {
+	pruned, err := prune.Prune(destHub, th.DestImage, p.destTags, p.stale, th.MaxPrune, th.DryRun, th.Progress)
+	if th.DryRun {
+		th.Progress.Printf("Would prune %d tags\n", pruned)
+	} else {
+		th.Progress.Printf("Pruned %d tags\n", pruned)
+	}
+	if err != nil {
+		return fmt.Errorf("pruning failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package tags
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Jeffail/tunny"
+	"github.com/Masterminds/semver"
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/progress"
+	"github.com/vbaksa/promoter/prune"
+	"github.com/vbaksa/promoter/report"
+)
+
+//Retention orders of tags kept by KeepLast
+const (
+	//OrderCreated orders tags by image creation time
+	OrderCreated = "created"
+	//OrderSemver orders tags by semantic version. Tags which are not semantic versions are always kept
+	OrderSemver = "semver"
+)
+
+//Retention holds retention policy of a repository. Image is deleted together with all of its tags when it is not kept by any policy:
+//KeepLast newest images, images having tag matching KeepRegexp and, when DeleteOlderThan is set, images created after that.
+//Without DeleteOlderThan images which can not be ordered, i.e. images without semantic version tag under OrderSemver, are kept.
+//Images without creation time are always kept unless KeepLast orders them by semver, as their age is unknown
+type Retention struct {
+	Registry        string
+	Image           string
+	Username        string
+	Password        string
+	Insecure        bool
+	KeepLast        int
+	Order           string
+	KeepRegexp      string
+	DeleteOlderThan string
+	DryRun          bool
+	//Progress receives status messages, Console when nil
+	Progress progress.Progress
+}
+
+type createdResult struct {
+	tag     string
+	created time.Time
+	err     error
+}
+
+//RetainTags deletes repository tags which are not kept by retention policy. Invalid policy is reported before Registry is connected.
+//Report describes every tag also when error is returned, unless tags could not be listed
+func (r *Retention) RetainTags() (*report.Report, error) {
+	p := progress.Or(r.Progress)
+	var keep *regexp.Regexp
+	var cutoff time.Time
+	var err error
+	if r.KeepLast <= 0 && len(r.DeleteOlderThan) == 0 {
+		return nil, errors.New("no retention policy specified, use --keep-last or --delete-older-than")
+	}
+	if r.Order != OrderCreated && r.Order != OrderSemver {
+		return nil, fmt.Errorf("invalid order %s, expected one of: created, semver", r.Order)
+	}
+	if len(r.KeepRegexp) > 0 {
+		if keep, err = regexp.Compile(r.KeepRegexp); err != nil {
+			return nil, fmt.Errorf("invalid keep regexp: %v", err)
+		}
+	}
+	if len(r.DeleteOlderThan) > 0 {
+		if cutoff, err = parseAge(r.DeleteOlderThan, time.Now()); err != nil {
+			return nil, fmt.Errorf("invalid delete older than value: %v", err)
+		}
+	}
+
+	p.Printf("Establishing connection...\n")
+	registryHub, err := connection.Connect(r.Registry, r.Username, r.Password, r.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Registry: %v", err)
+	}
+	hub := backend.NewRegistry(registryHub)
+	tags, err := hub.Tags(r.Image)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while trying to get Image Tags: %v", err)
+	}
+	p.Printf("Image %s contains %d tags\n", r.Image, len(tags))
+
+	result := report.New()
+	var created map[string]time.Time
+	if r.Order == OrderCreated || !cutoff.IsZero() {
+		if created, err = createdTimes(hub, r.Image, tags); err != nil {
+			result.Finish()
+			return result, err
+		}
+	}
+	digests, err := prune.ManifestDigests(hub, r.Image, tags)
+	if err != nil {
+		result.Finish()
+		return result, err
+	}
+	expired := r.expiredTags(tags, digests, created, keep, cutoff)
+	p.Printf("Retention policy keeps %d of %d tags\n", len(tags)-len(expired), len(tags))
+	//Tags were already selected by retention policy, so the prune limit does not apply
+	deletions, err := prune.Delete(hub, r.Image, tags, expired, -1, r.DryRun, p)
+	r.addResults(result, tags, digests, deletions)
+	result.Finish()
+	if r.DryRun {
+		p.Printf("Dry run done! Would delete %d tags\n", result.Planned)
+	} else {
+		p.Printf("All done! Deleted %d tags\n", result.Deleted)
+	}
+	return result, err
+}
+
+//addResults records status of every tag. Expired tags sharing manifest with kept tag are kept
+func (r *Retention) addResults(result *report.Report, tags []string, digests map[string]digest.Digest, deletions []*prune.Deletion) {
+	byTag := make(map[string]*prune.Deletion)
+	for _, d := range deletions {
+		byTag[d.Tag] = d
+	}
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	for _, tag := range sorted {
+		t := &report.Tag{
+			Source:       report.Reference(r.Registry, r.Image, tag),
+			SourceDigest: digests[tag],
+			Status:       report.TagKept,
+		}
+		if d, ok := byTag[tag]; ok {
+			switch {
+			case d.Err != nil:
+				t.Fail(d.Err)
+			case d.Pruned && r.DryRun:
+				t.Status = report.TagPlanned
+			case d.Pruned:
+				t.Status = report.TagDeleted
+			}
+		}
+		result.Add(t)
+	}
+}
+
+//retainedImage groups tags pointing to the same manifest. Such tags can only be deleted together, so retention policy is applied to images instead of tags
+type retainedImage struct {
+	tags    []string
+	created time.Time
+	version *semver.Version
+}
+
+//expiredTags returns tags of images which are not kept by any retention policy.
+//Order only selects images kept by KeepLast, age policy applies to every image. Images missing in created have unknown age, they are
+//not ordered by OrderCreated and age policy keeps them
+func (r *Retention) expiredTags(tags []string, digests map[string]digest.Digest, created map[string]time.Time, keep *regexp.Regexp, cutoff time.Time) []string {
+	images := make([]*retainedImage, 0)
+	byDigest := make(map[digest.Digest]*retainedImage)
+	for _, tag := range tags {
+		img, ok := byDigest[digests[tag]]
+		if !ok {
+			img = &retainedImage{created: created[tag]}
+			byDigest[digests[tag]] = img
+			images = append(images, img)
+		}
+		img.tags = append(img.tags, tag)
+		if v, err := semver.NewVersion(tag); err == nil && (img.version == nil || v.GreaterThan(img.version)) {
+			img.version = v
+		}
+	}
+	ordered := make([]*retainedImage, 0, len(images))
+	kept := make(map[*retainedImage]bool)
+	for _, img := range images {
+		if (r.Order == OrderCreated && !img.created.IsZero()) || (r.Order == OrderSemver && img.version != nil) {
+			ordered = append(ordered, img)
+		} else if cutoff.IsZero() || img.created.IsZero() {
+			//Images which can not be ordered are deleted only by age policy, which keeps images of unknown age
+			kept[img] = true
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if r.Order == OrderSemver {
+			return ordered[i].version.GreaterThan(ordered[j].version)
+		}
+		return ordered[i].created.After(ordered[j].created)
+	})
+	for i := 0; i < r.KeepLast && i < len(ordered); i++ {
+		kept[ordered[i]] = true
+	}
+	expired := make([]string, 0)
+	for _, img := range images {
+		if kept[img] {
+			continue
+		}
+		if !cutoff.IsZero() && (img.created.IsZero() || !img.created.Before(cutoff)) {
+			continue
+		}
+		if keep != nil && matchesAny(keep, img.tags) {
+			continue
+		}
+		expired = append(expired, img.tags...)
+	}
+	return expired
+}
+
+func matchesAny(r *regexp.Regexp, tags []string) bool {
+	for _, tag := range tags {
+		if r.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+//createdTimes reads image creation time of every tag from image configuration
+func createdTimes(hub backend.Backend, image string, tags []string) (map[string]time.Time, error) {
+	createdQueue := tunny.NewFunc(5, func(payload interface{}) interface{} {
+		tag := payload.(string)
+		m, err := hub.Manifest(image, tag)
+		if err != nil {
+			return &createdResult{tag: tag, err: err}
+		}
+		if len(m.History) == 0 {
+			return &createdResult{tag: tag, err: errors.New("image manifest does not contain history")}
+		}
+		var img v1Image
+		if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &img); err != nil {
+			return &createdResult{tag: tag, err: fmt.Errorf("cannot parse image configuration: %v", err)}
+		}
+		return &createdResult{tag: tag, created: img.Created}
+	})
+	defer createdQueue.Close()
+
+	resultChannel := make(chan *createdResult)
+	for _, tag := range tags {
+		go func(tag string) {
+			resultChannel <- createdQueue.Process(tag).(*createdResult)
+		}(tag)
+	}
+	created := make(map[string]time.Time)
+	var err error
+	for range tags {
+		res := <-resultChannel
+		if res.err != nil {
+			err = fmt.Errorf("cannot read creation time of tag %s: %v", res.tag, res.err)
+			continue
+		}
+		created[res.tag] = res.created
+	}
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
@@ -0,0 +1,168 @@
+package tags
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+)
+
+var now = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func daysAgo(days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
+
+//retainedTag describes tag of test repository
+type retainedTag struct {
+	tag string
+	//image names manifest of the tag, tags of the same image share digest
+	image   string
+	created time.Time
+}
+
+func TestExpiredTags(t *testing.T) {
+	repository := []retainedTag{
+		{"1.0.0", "a", daysAgo(100)},
+		{"1.1.0", "b", daysAgo(50)},
+		{"1.2.0", "c", daysAgo(30)},
+		{"latest", "c", daysAgo(30)},
+		{"2.0.0", "d", daysAgo(40)},
+		{"2.0.1", "e", daysAgo(5)},
+		{"stable", "e", daysAgo(5)},
+		{"nightly", "f", daysAgo(1)},
+		{"feature-x", "g", daysAgo(60)},
+	}
+	tests := []struct {
+		name       string
+		retention  Retention
+		keepRegexp string
+		cutoff     time.Time
+		expired    []string
+	}{
+		{
+			name:      "keep last by semver",
+			retention: Retention{KeepLast: 2, Order: OrderSemver},
+			//Images without semantic version tag are kept
+			expired: []string{"1.0.0", "1.1.0", "1.2.0", "latest"},
+		},
+		{
+			name:      "keep last by created",
+			retention: Retention{KeepLast: 3, Order: OrderCreated},
+			expired:   []string{"1.0.0", "1.1.0", "2.0.0", "feature-x"},
+		},
+		{
+			name:      "keep last counts images instead of tags",
+			retention: Retention{KeepLast: 1, Order: OrderSemver},
+			expired:   []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "latest"},
+		},
+		{
+			name:       "keep regexp",
+			retention:  Retention{KeepLast: 1, Order: OrderCreated},
+			keepRegexp: "^(latest|1\\.0\\.0)$",
+			expired:    []string{"1.1.0", "2.0.0", "2.0.1", "feature-x", "stable"},
+		},
+		{
+			name:      "age cutoff",
+			retention: Retention{Order: OrderCreated},
+			cutoff:    daysAgo(45),
+			expired:   []string{"1.0.0", "1.1.0", "feature-x"},
+		},
+		{
+			name:      "age cutoff together with keep last",
+			retention: Retention{KeepLast: 4, Order: OrderCreated},
+			cutoff:    daysAgo(35),
+			expired:   []string{"1.0.0", "1.1.0", "feature-x"},
+		},
+		{
+			name:      "age cutoff deletes images without semantic version",
+			retention: Retention{KeepLast: 1, Order: OrderSemver},
+			cutoff:    daysAgo(45),
+			expired:   []string{"1.0.0", "1.1.0", "feature-x"},
+		},
+		{
+			name:       "image is kept when any of its tags matches keep regexp",
+			retention:  Retention{Order: OrderCreated},
+			keepRegexp: "^stable$",
+			cutoff:     daysAgo(0),
+			expired:    []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "feature-x", "latest", "nightly"},
+		},
+	}
+	for _, test := range tests {
+		var keep *regexp.Regexp
+		if len(test.keepRegexp) > 0 {
+			keep = regexp.MustCompile(test.keepRegexp)
+		}
+		tags, digests, created := retainedRepository(repository)
+		expired := test.retention.expiredTags(tags, digests, created, keep, test.cutoff)
+		sort.Strings(expired)
+		if !reflect.DeepEqual(expired, test.expired) {
+			t.Errorf("%s: expired tags are %v, expected %v", test.name, expired, test.expired)
+		}
+	}
+}
+
+func TestExpiredTagsWithoutCreationTime(t *testing.T) {
+	repository := []retainedTag{
+		{"1.0.0", "a", daysAgo(100)},
+		{"1.1.0", "b", time.Time{}},
+		{"nightly", "c", time.Time{}},
+		{"2.0.0", "d", daysAgo(1)},
+	}
+	tests := []struct {
+		name      string
+		retention Retention
+		cutoff    time.Time
+		expired   []string
+	}{
+		{
+			name:      "age cutoff",
+			retention: Retention{Order: OrderCreated},
+			cutoff:    daysAgo(30),
+			expired:   []string{"1.0.0"},
+		},
+		{
+			name:      "keep last by created",
+			retention: Retention{KeepLast: 1, Order: OrderCreated},
+			expired:   []string{"1.0.0"},
+		},
+		{
+			name:      "keep last by semver with age cutoff",
+			retention: Retention{KeepLast: 1, Order: OrderSemver},
+			cutoff:    daysAgo(30),
+			expired:   []string{"1.0.0"},
+		},
+		{
+			//Creation time is not read when only semantic versions are ordered
+			name:      "keep last by semver",
+			retention: Retention{KeepLast: 1, Order: OrderSemver},
+			expired:   []string{"1.0.0", "1.1.0"},
+		},
+	}
+	for _, test := range tests {
+		tags, digests, created := retainedRepository(repository)
+		expired := test.retention.expiredTags(tags, digests, created, nil, test.cutoff)
+		sort.Strings(expired)
+		if !reflect.DeepEqual(expired, test.expired) {
+			t.Errorf("%s: expired tags are %v, expected %v", test.name, expired, test.expired)
+		}
+	}
+}
+
+//retainedRepository returns tags, their digests and creation times. Zero creation time is left out of created times
+func retainedRepository(repository []retainedTag) ([]string, map[string]digest.Digest, map[string]time.Time) {
+	tags := make([]string, 0, len(repository))
+	digests := make(map[string]digest.Digest)
+	created := make(map[string]time.Time)
+	for _, rt := range repository {
+		tags = append(tags, rt.tag)
+		digests[rt.tag] = digest.FromBytes([]byte(rt.image))
+		if !rt.created.IsZero() {
+			created[rt.tag] = rt.created
+		}
+	}
+	return tags, digests, created
+}
@@ -0,0 +1,181 @@
+package tags
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/vbaksa/promoter/progress"
+)
+
+//Validate checks every tag selector and destination tag template, so invalid options are reported before any registry request
+func (th *TagPush) Validate() error {
+	if len(th.TagRegexp) > 0 {
+		if _, err := regexp.Compile(th.TagRegexp); err != nil {
+			return fmt.Errorf("invalid tag regexp: %v", err)
+		}
+	}
+	if len(th.TagExcludeRegexp) > 0 {
+		if _, err := regexp.Compile(th.TagExcludeRegexp); err != nil {
+			return fmt.Errorf("invalid tag exclude regexp: %v", err)
+		}
+	}
+	if len(th.TagSemver) > 0 {
+		if _, err := semver.NewConstraint(th.TagSemver); err != nil {
+			return fmt.Errorf("invalid semver constraint: %v", err)
+		}
+	}
+	if th.Latest < 0 {
+		return fmt.Errorf("invalid number of latest versions %d", th.Latest)
+	}
+	if len(th.TagsFrom) > 0 {
+		f, err := os.Open(th.TagsFrom)
+		if err != nil {
+			return fmt.Errorf("failed to read tags file: %v", err)
+		}
+		f.Close()
+	}
+	if _, err := th.newImageFilter(time.Now()); err != nil {
+		return err
+	}
+	if _, err := th.newTagTemplate(); err != nil {
+		return fmt.Errorf("invalid destination tag template: %v", err)
+	}
+	if th.Prune && len(th.DestTagTemplate) > 0 {
+		return fmt.Errorf("prune can not be combined with destination tag template, destination tags can not be mapped back to source tags")
+	}
+	return nil
+}
+
+//selectTags applies tag selectors in the following order: tags file, include regexp, exclude regexp, semver constraint, latest versions
+func (th *TagPush) selectTags(tags []string) ([]string, error) {
+	var err error
+	if len(th.TagsFrom) > 0 {
+		tags, err = filterByFile(tags, th.TagsFrom, th.Progress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tags file: %v", err)
+		}
+		th.Progress.Printf("Tags file matched %d tags \n", len(tags))
+	}
+	if len(th.TagRegexp) > 0 {
+		tags, err = filterByVersionSelector(tags, th.TagRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter by provided Tag regexp: %v", err)
+		}
+		th.Progress.Printf("Tag regexp matched %d images \n", len(tags))
+	}
+	if len(th.TagExcludeRegexp) > 0 {
+		tags, err = excludeByRegexp(tags, th.TagExcludeRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter by provided Tag exclude regexp: %v", err)
+		}
+		th.Progress.Printf("%d tags left after exclusion \n", len(tags))
+	}
+	if len(th.TagSemver) > 0 {
+		tags, err = filterBySemver(tags, th.TagSemver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter by provided semver constraint: %v", err)
+		}
+		th.Progress.Printf("Semver constraint matched %d tags \n", len(tags))
+	}
+	if th.Latest > 0 {
+		tags = latestVersions(tags, th.Latest)
+		th.Progress.Printf("Selected %d latest versions: %s \n", len(tags), strings.Join(tags, ", "))
+	}
+	return tags, nil
+}
+
+//filterByFile keeps tags listed in file, one tag per line. Empty lines and lines starting with # are ignored
+func filterByFile(tags []string, path string, p progress.Progress) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	available := make(map[string]bool)
+	for _, tag := range tags {
+		available[tag] = true
+	}
+	filteredTags := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tag := strings.TrimSpace(scanner.Text())
+		if len(tag) == 0 || strings.HasPrefix(tag, "#") || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		if !available[tag] {
+			p.Printf("Tag listed in tags file does not exist on Source Image: %s\n", tag)
+			continue
+		}
+		filteredTags = append(filteredTags, tag)
+	}
+	return filteredTags, scanner.Err()
+}
+
+func excludeByRegexp(tags []string, filter string) ([]string, error) {
+	r, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	filteredTags := make([]string, 0)
+	for _, tag := range tags {
+		if !r.MatchString(tag) {
+			filteredTags = append(filteredTags, tag)
+		}
+	}
+	return filteredTags, nil
+}
+
+//filterBySemver keeps tags which are semantic versions satisfying the constraint, e.g. ">=1.4.0 <2.0.0".
+//Prerelease versions are only selected when constraint includes a prerelease, e.g. ">=1.4.0-0"
+func filterBySemver(tags []string, constraint string) ([]string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	filteredTags := make([]string, 0)
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if c.Check(v) {
+			filteredTags = append(filteredTags, tag)
+		}
+	}
+	return filteredTags, nil
+}
+
+//latestVersions returns n highest semantic versions ordered from the highest. Tags which are not semantic versions are skipped
+func latestVersions(tags []string, n int) []string {
+	type version struct {
+		tag     string
+		version *semver.Version
+	}
+	versions := make([]version, 0, len(tags))
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version{tag: tag, version: v})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].version.GreaterThan(versions[j].version)
+	})
+	if len(versions) > n {
+		versions = versions[:n]
+	}
+	latest := make([]string, 0, len(versions))
+	for _, v := range versions {
+		latest = append(latest, v.tag)
+	}
+	return latest
+}
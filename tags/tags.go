@@ -1,47 +1,81 @@
 package tags
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
-
-	"os"
+	"time"
 
 	manifestV1 "github.com/docker/distribution/manifest/schema1"
 
 	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
 	"github.com/docker/libtrust"
-	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/progress"
 	"github.com/vbaksa/promoter/progressbar"
-	"gopkg.in/cheggaaa/pb.v1"
-	"io/ioutil"
-	"log"
+	"github.com/vbaksa/promoter/report"
 )
 
+//ErrNoTagsSelected is returned when tag or image selectors didn't match any tags
+var ErrNoTagsSelected = errors.New("selectors didn't match any tags")
+
+//FailedError is returned when some tags failed to push. Report describes error of each tag
+type FailedError struct {
+	Failed int
+}
+
+func (e *FailedError) Error() string {
+	return fmt.Sprintf("failed to push %d tags", e.Failed)
+}
+
 //TagPush holds image tags promotion structure
 type TagPush struct {
-	SrcRegistry  string
-	SrcImage     string
-	SrcUsername  string
-	SrcPassword  string
-	SrcInsecure  bool
-	DestRegistry string
-	DestImage    string
-	DestUsername string
-	DestPassword string
-	DestInsecure bool
-	TagRegexp    string
-	Debug        bool
+	SrcRegistry      string
+	SrcImage         string
+	DestRegistry     string
+	DestImage        string
+	TagRegexp        string
+	TagExcludeRegexp string
+	TagSemver        string
+	Latest           int
+	TagsFrom         string
+	NewerThan        string
+	OlderThan        string
+	Labels           []string
+	Overwrite        string
+	Force            bool
+	DestTagTemplate  string
+	Prune            bool
+	MaxPrune         int
+	DryRun           bool
+	//Progress receives status messages and progress bars, they are printed to stdout when Progress is nil
+	Progress progress.Progress
+	//Promoted remembers digests of promoted tags, so unchanged tags promoted under another name are skipped by HEAD requests only.
+	//Nil Promoted remembers nothing
+	Promoted *image.DigestMap
+
+	result *report.Report
+	//digests holds manifest digests of source tags checked by skipUnchanged
+	digests map[string]image.Digests
 }
 type manifestGetResult struct {
 	manifest manifestV1.SignedManifest
 	tag      string
+	destTag  string
 	err      error
+	//started is time when work on the tag began by fetching its source manifest
+	started time.Time
 }
 type layerCheck struct {
 	layer       manifestV1.FSLayer
 	size        int64
 	remoteExist bool
+	mounted     bool
 	err         error
 }
 type uploadResult struct {
@@ -49,51 +83,90 @@ type uploadResult struct {
 	err   error
 }
 type manifestDeployResult struct {
-	destManifest manifestV1.SignedManifest
+	source       manifestGetResult
+	destManifest *manifestV1.SignedManifest
 	err          error
+	started      time.Time
+	finished     time.Time
 }
 
-//PushTags promotes all specified image tags.
-func (th *TagPush) PushTags() {
-	if !th.Debug {
-		log.SetOutput(ioutil.Discard)
+//Push promotes selected image tags between connected registries and prunes stale destination tags.
+//Report describes every tag also when error is returned. Error is ErrNoTagsSelected when selectors didn't match any tags
+//and *FailedError when some tags failed to push
+func (th *TagPush) Push(ctx context.Context, srcHub backend.Backend, destHub backend.Backend) (*report.Report, error) {
+	th.Progress = progress.Or(th.Progress)
+	th.result = report.New()
+	stale, err := th.push(ctx, srcHub, destHub)
+	if _, failed := err.(*FailedError); stale != nil && (err == nil || failed) {
+		if pruneErr := th.pruneStale(destHub, stale); pruneErr != nil && err == nil {
+			err = pruneErr
+		}
 	}
-	fmt.Println("Preparing tags push")
-	srcHub, destHub := connection.InitConnection(th.SrcRegistry, th.SrcUsername, th.SrcPassword, th.SrcInsecure, th.DestRegistry, th.DestUsername, th.DestPassword, th.DestInsecure)
-	fmt.Println("Source Image: " + th.SrcImage)
-	fmt.Println("Destination image: " + th.DestImage)
+	th.result.Finish()
+	return th.result, err
+}
+
+func (th *TagPush) push(ctx context.Context, srcHub backend.Backend, destHub backend.Backend) (*pruning, error) {
+	p := th.Progress
+	p.Printf("Source Image: %s\n", th.SrcImage)
+	p.Printf("Destination image: %s\n", th.DestImage)
 	tags, err := srcHub.Tags(th.SrcImage)
 	if err != nil {
-		fmt.Println("Error occurred while trying to get Source Image Tags")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("error occurred while trying to get Source Image Tags: %v", err)
 	}
 
 	totalTags := len(tags)
+	srcTags := tags
 
-	fmt.Printf("Source image contains %d tags\n", totalTags)
+	p.Printf("Source image contains %d tags\n", totalTags)
 
-	if len(th.TagRegexp) > 0 {
-		tags, err = filterByVersionSelector(tags, th.TagRegexp)
+	tags, err = th.selectTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := th.newImageFilter(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	tagTemplate, err := th.newTagTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination tag template: %v", err)
+	}
+	var stale *pruning
+	if th.Prune {
+		stale, err = th.staleTags(destHub, srcTags)
 		if err != nil {
-			fmt.Printf("Failed to filter by provided Tag regexp. Error: %q \n", err)
-			os.Exit(1)
-		} else {
-			fmt.Printf("Tag regexp matched %d images \n", len(tags))
-		}
-		if len(tags) == 0 {
-			fmt.Println("Image Tag Regexp didn't matched any tags")
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to prepare pruning: %v", err)
 		}
 	}
+	if len(tags) == 0 {
+		p.Printf("Tag selectors didn't match any tags\n")
+		return stale, th.noTags()
+	}
+	//TO-DO parametrize number of connections
+	poolSize := 5
+
+	tags, skipped := th.skipUnchanged(srcHub, destHub, tags, tagTemplate, poolSize)
+	if len(tags) == 0 {
+		p.Printf("All done! Pushed 0 tags, skipped %d unchanged tags\n", skipped)
+		return stale, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	layers := make([]manifestV1.FSLayer, 0)
 	manifests := make([]manifestGetResult, 0)
-	//TO-DO parametrize number of connections
-	poolSize := 5
 
 	manifestGetQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
 		tag := payload.(string)
+		if err := ctx.Err(); err != nil {
+			return &manifestGetResult{
+				err: err,
+				tag: tag,
+			}
+		}
+		started := time.Now()
 		manifest, err := srcHub.Manifest(th.SrcImage, tag)
 		if err != nil {
 			return &manifestGetResult{
@@ -105,6 +178,7 @@ func (th *TagPush) PushTags() {
 			manifest: *manifest,
 			tag:      tag,
 			err:      nil,
+			started:  started,
 		}
 	})
 	defer manifestGetQueue.Close()
@@ -117,20 +191,52 @@ func (th *TagPush) PushTags() {
 			manifestGetResultChannel <- result.(*manifestGetResult)
 		}(tags[i])
 	}
-	manifestGetProgressBar := pb.New(len(tags)).SetUnits(pb.U_NO)
-	manifestGetProgressBar.Start()
+	manifestGetProgressBar := p.Stage(progress.StageManifests, int64(len(tags)), progress.Count)
 	//Pull manifest
 	for i := 0; i < len(tags); i++ {
 		res := <-manifestGetResultChannel
 		manifestGetProgressBar.Add(1)
+		if res.err == nil {
+			p.Event(progress.Event{Type: progress.EventManifestResolved, Registry: th.SrcRegistry, Repository: th.SrcImage, Tag: res.tag, Digest: digest.FromBytes(res.manifest.Canonical).String()})
+		}
 		manifests = append(manifests, *res)
 	}
 	manifestGetProgressBar.Finish()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		manifests = th.filterManifests(manifests, filter)
+		if len(manifests) == 0 {
+			p.Printf("Image selectors didn't match any tags\n")
+			return stale, th.noTags()
+		}
+	}
+	manifests, refused := th.renderDestTags(tagTemplate, manifests)
+	manifests, overwriteRefused, sameImages := th.checkOverwrite(destHub, manifests, poolSize)
+	refused = append(refused, overwriteRefused...)
+	skipped = skipped + sameImages
+	if th.DryRun {
+		var push int
+		for _, m := range manifests {
+			if m.err == nil {
+				p.Printf("Would push tag %s as %s:%s\n", m.tag, th.DestImage, m.destTag)
+				th.result.Add(th.tagResult(m, report.TagPlanned))
+				push++
+			}
+		}
+		failed := th.reportRefused(manifests, refused)
+		p.Printf("Dry run done! Would push %d tags, skipped %d unchanged tags\n", push, skipped)
+		return stale, failedError(failed)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	for i := 0; i < len(manifests); i++ {
 		layers = append(layers, manifests[i].manifest.FSLayers...)
 	}
-	fmt.Printf("Total number of layers %d \n", len(layers))
+	p.Printf("Total number of layers %d \n", len(layers))
 	uniqueLayers := make([]manifestV1.FSLayer, 0)
 
 	for _, layer := range layers {
@@ -138,12 +244,18 @@ func (th *TagPush) PushTags() {
 	}
 	if len(layers) > len(uniqueLayers) {
 		duplicateLayerCount := len(layers) - len(uniqueLayers)
-		fmt.Printf("Reducing transfer size by skipping duplicate layers. Duplicate layers skipped: %d \n", duplicateLayerCount)
+		p.Printf("Reducing transfer size by skipping duplicate layers. Duplicate layers skipped: %d \n", duplicateLayerCount)
 	}
-	fmt.Println("Retrieving layer metadata and optimising transfer..")
+	p.Printf("Retrieving layer metadata and optimising transfer..\n")
 
 	layerSizeGetQueue := tunny.NewFunc(10, func(payload interface{}) interface{} {
 		layer := payload.(manifestV1.FSLayer)
+		if err := ctx.Err(); err != nil {
+			return &layerCheck{
+				layer: layer,
+				err:   err,
+			}
+		}
 		metadata, err := srcHub.LayerMetadata(th.SrcImage, layer.BlobSum)
 		if err != nil {
 			return &layerCheck{
@@ -165,13 +277,16 @@ func (th *TagPush) PushTags() {
 		}
 		exist, _ := destHub.HasLayer(th.DestImage, layerCheck.layer.BlobSum)
 		layerCheck.remoteExist = exist
+		if !exist {
+			mounted, _ := layer.MountLayers(destHub, th.DestImage, srcHub, th.SrcImage, []digest.Digest{layerCheck.layer.BlobSum})
+			layerCheck.mounted = len(mounted) > 0
+		}
 		return layerCheck
 	})
 	defer layerSizeGetQueue.Close()
 	defer layerExistQueue.Close()
 
-	layerCheckProgressBar := pb.New(len(uniqueLayers)).SetUnits(pb.U_NO)
-	layerCheckProgressBar.Start()
+	layerCheckProgressBar := p.Stage(progress.StageLayers, int64(len(uniqueLayers)), progress.Count)
 
 	layerCheckChannel := make(chan *layerCheck)
 	for i := 0; i < len(uniqueLayers); i++ {
@@ -186,36 +301,32 @@ func (th *TagPush) PushTags() {
 	for i := 0; i < len(uniqueLayers); i++ {
 		res := <-layerCheckChannel
 		layerCheckProgressBar.Add(1)
+		if res.err == nil && (res.remoteExist || res.mounted) {
+			reason := progress.ReasonExists
+			if res.mounted {
+				reason = progress.ReasonMounted
+			}
+			p.Event(progress.Event{Type: progress.EventLayerSkipped, Registry: th.DestRegistry, Repository: th.DestImage, Digest: res.layer.BlobSum.String(), Reason: reason})
+		}
 		layerCheckResults = append(layerCheckResults, *res)
 	}
 	layerCheckProgressBar.Finish()
 
-	fmt.Println("Transferring layers...")
-	var totalReader = make(chan int64)
+	p.Printf("Transferring layers...\n")
+	total := &progressbar.Counter{}
 	uploadResultChannel := make(chan *uploadResult)
 	uploadResults := make([]uploadResult, 0)
 	uploadQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
-		upload := payload.(manifestV1.FSLayer)
-		reader, err := srcHub.DownloadLayer(th.SrcImage, upload.BlobSum)
-		if reader != nil {
-			defer reader.Close()
-		}
-		var err2 error
-		if totalReader != nil {
-			rd := &progressbar.PassThru{ReadCloser: reader, Total: &totalReader}
-			err2 = destHub.UploadLayer(th.DestImage, upload.BlobSum, rd)
-		} else {
-			err2 = destHub.UploadLayer(th.DestImage, upload.BlobSum, reader)
+		upload := payload.(layerCheck)
+		err := ctx.Err()
+		if err == nil {
+			err = layer.TransferLayer(destHub, th.DestImage, srcHub, th.SrcImage, upload.layer.BlobSum, p.Transfer(th.DestImage, upload.layer.BlobSum.String(), upload.size), total)
 		}
 		if err != nil {
-			fmt.Printf("Error occurred while uploading layer:  %s. Error: %s \n", upload.BlobSum, err.Error())
-		}
-		if err2 != nil {
-			fmt.Printf("Error occurred while uploading layer:  %s. Error: %s \n", upload.BlobSum, err2.Error())
+			p.Printf("Error occurred while uploading layer:  %s. Error: %s \n", upload.layer.BlobSum, err.Error())
 		}
-
 		return &uploadResult{
-			layer: upload,
+			layer: upload.layer,
 			err:   err,
 		}
 	})
@@ -224,55 +335,62 @@ func (th *TagPush) PushTags() {
 	//Get total transfer size
 	var transferSize int64
 	for _, layerCheckResult := range layerCheckResults {
-		if !layerCheckResult.remoteExist {
+		if !layerCheckResult.remoteExist && !layerCheckResult.mounted {
 			transferSize = transferSize + layerCheckResult.size
 		}
 	}
-	uploadProgressBar := pb.New64(transferSize * 2).SetUnits(pb.U_BYTES)
-	uploadProgressBar.Start()
+	uploadProgressBar := p.Stage(progress.StageUpload, transferSize, progress.Bytes)
 
 	//Submit upload
 	for _, layerCheckResult := range layerCheckResults {
-		if layerCheckResult.err == nil && !layerCheckResult.remoteExist {
-			go func(layer manifestV1.FSLayer) {
-				result := uploadQueue.Process(layer)
+		if layerCheckResult.err == nil && !layerCheckResult.remoteExist && !layerCheckResult.mounted {
+			go func(upload layerCheck) {
+				result := uploadQueue.Process(upload)
 				uploadResultChannel <- result.(*uploadResult)
-			}(layerCheckResult.layer)
+			}(layerCheckResult)
 		}
 		if layerCheckResult.err != nil {
-			fmt.Printf("Failed to retrieve layer %s data. Error: %s \n", layerCheckResult.layer.BlobSum, layerCheckResult.err.Error())
+			p.Printf("Failed to retrieve layer %s data. Error: %s \n", layerCheckResult.layer.BlobSum, layerCheckResult.err.Error())
 		}
 	}
-	//Constantly update progress bar
-	go func() {
-		for {
-			t := <-totalReader
-			uploadProgressBar.Add64(t * 2)
-		}
-	}()
+	//Periodically update progress bar
+	sampler := total.Sample(progressbar.SampleInterval, uploadProgressBar.Add)
 
 	//Collect upload results
 	for _, layerCheckResult := range layerCheckResults {
-		if layerCheckResult.err == nil && !layerCheckResult.remoteExist {
+		if layerCheckResult.err == nil && !layerCheckResult.remoteExist && !layerCheckResult.mounted {
 			res := <-uploadResultChannel
 			uploadResults = append(uploadResults, *res)
 		}
 	}
+	sampler.Stop()
+	th.result.BytesTransferred = th.result.BytesTransferred + total.Value()
 	uploadProgressBar.Finish()
+	layerResults := layerReports(layerCheckResults, uploadResults)
 
 	//Deploy manifest files
-	fmt.Println("Uploading Manifest files...")
+	p.Printf("Uploading Manifest files...\n")
 	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while generating Image Key: %v", err)
+	}
 	manifestDeployResultChannel := make(chan *manifestDeployResult)
 	manifestDeployResults := make([]manifestDeployResult, 0)
 	manifestDeployQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
-		srcManifest := payload.(manifestV1.SignedManifest)
+		m := payload.(manifestGetResult)
+		if err := ctx.Err(); err != nil {
+			return &manifestDeployResult{
+				source: m,
+				err:    err,
+			}
+		}
+		srcManifest := m.manifest
 		destManifest := &manifestV1.Manifest{
 			Versioned: manifest.Versioned{
 				SchemaVersion: 1,
 			},
 			Name:         th.DestImage,
-			Tag:          srcManifest.Tag,
+			Tag:          m.destTag,
 			Architecture: srcManifest.Architecture,
 			FSLayers:     srcManifest.FSLayers,
 			History:      srcManifest.History,
@@ -280,30 +398,35 @@ func (th *TagPush) PushTags() {
 		signedDestManifest, err := manifestV1.Sign(destManifest, key)
 		if err != nil {
 			return &manifestDeployResult{
-				destManifest: *signedDestManifest,
-				err:          err,
+				source: m,
+				err:    err,
 			}
 		}
-		err = destHub.PutManifest(th.DestImage, srcManifest.Tag, signedDestManifest)
+		err = destHub.PutManifest(th.DestImage, m.destTag, signedDestManifest)
+		if digests, known := th.digests[m.tag]; err == nil && known {
+			th.Promoted.RecordPushed(destHub, th.DestImage, m.destTag, report.Reference(th.DestRegistry, th.DestImage, m.destTag), digests.Source)
+		}
 
 		return &manifestDeployResult{
-			destManifest: *signedDestManifest,
+			source:       m,
+			destManifest: signedDestManifest,
 			err:          err,
+			started:      m.started,
+			finished:     time.Now(),
 		}
 	})
 	defer manifestDeployQueue.Close()
 
 	for i := 0; i < len(manifests); i++ {
 		if manifests[i].err == nil {
-			go func(manifest manifestV1.SignedManifest) {
-				result := manifestDeployQueue.Process(manifest)
+			go func(m manifestGetResult) {
+				result := manifestDeployQueue.Process(m)
 				manifestDeployResultChannel <- result.(*manifestDeployResult)
-			}(manifests[i].manifest)
+			}(manifests[i])
 
 		}
 	}
-	manifestDeployProgressBar := pb.New(len(manifests)).SetUnits(pb.U_NO)
-	manifestDeployProgressBar.Start()
+	manifestDeployProgressBar := p.Stage(progress.StageDeploy, int64(len(manifests)), progress.Count)
 
 	//Collect manifest deployment results
 	for i := 0; i < len(manifests); i++ {
@@ -315,25 +438,131 @@ func (th *TagPush) PushTags() {
 	}
 	manifestDeployProgressBar.Finish()
 	//Report failed deployments
-	var errorsFound bool
+	failed := th.reportRefused(manifests, refused)
+	var pushed int
+	for _, manifestDeployResult := range manifestDeployResults {
+		t := th.tagResult(manifestDeployResult.source, report.TagPushed)
+		if !manifestDeployResult.started.IsZero() && !manifestDeployResult.finished.IsZero() {
+			t.DurationSeconds = manifestDeployResult.finished.Sub(manifestDeployResult.started).Seconds()
+		}
+		for _, l := range manifestDeployResult.source.manifest.FSLayers {
+			if r, ok := layerResults[l.BlobSum]; ok && !containsLayer(t.Layers, r) {
+				t.Layers = append(t.Layers, r)
+			}
+		}
+		th.result.Add(t)
+		if manifestDeployResult.err != nil {
+			p.Printf("Failed to push image %s because unable to deploy image manifest. Error: %s \n", th.DestImage+":"+manifestDeployResult.source.destTag, manifestDeployResult.err.Error())
+			t.Fail(manifestDeployResult.err)
+			th.tagFailed(t, manifestDeployResult.source)
+			failed++
+		} else {
+			t.DestinationDigest = digest.FromBytes(manifestDeployResult.destManifest.Canonical)
+			p.Event(progress.Event{Type: progress.EventManifestPushed, Registry: th.DestRegistry, Repository: th.DestImage, Tag: manifestDeployResult.source.destTag, Digest: t.DestinationDigest.String()})
+			pushed++
+		}
+	}
+	p.Printf("All done! Pushed %d tags, skipped %d unchanged tags\n", pushed, skipped)
+	return stale, failedError(failed)
+}
+
+//noTags returns ErrNoTagsSelected unless stale tags are pruned
+func (th *TagPush) noTags() error {
+	if th.Prune {
+		return nil
+	}
+	return ErrNoTagsSelected
+}
+
+func failedError(failed int) error {
+	if failed > 0 {
+		return &FailedError{Failed: failed}
+	}
+	return nil
+}
+
+//reportRefused reports tags which failed to download or were refused before any push. Returns number of such tags
+func (th *TagPush) reportRefused(manifests []manifestGetResult, refused []overwriteCheck) int {
+	var failed int
 	for i := 0; i < len(manifests); i++ {
 		if manifests[i].err != nil {
-			fmt.Printf("Failed to push image %s because unable to retrieve image manifest. Error: %s \n", manifests[i].manifest.Name+":"+manifests[i].tag, manifests[i].err.Error())
-			errorsFound = true
+			th.Progress.Printf("Failed to push image %s because unable to retrieve image manifest. Error: %s \n", th.SrcImage+":"+manifests[i].tag, manifests[i].err.Error())
+			th.failed(manifests[i], manifests[i].err)
+			failed++
 		}
 	}
-	for _, manifestDeployResult := range manifestDeployResults {
-		if manifestDeployResult.err != nil {
-			fmt.Printf("Failed to push image %s because unable to deploy image manifest. Error: %s \n", manifestDeployResult.destManifest.Name+":"+manifestDeployResult.destManifest.Tag, manifestDeployResult.err.Error())
-			errorsFound = true
+	for _, r := range refused {
+		th.Progress.Printf("Failed to push image %s. Error: %s \n", th.SrcImage+":"+r.manifest.tag, r.err.Error())
+		th.failed(r.manifest, r.err)
+		failed++
+	}
+	return failed
+}
+
+//tagResult starts report of tag promotion
+func (th *TagPush) tagResult(m manifestGetResult, status string) *report.Tag {
+	t := &report.Tag{
+		Source: report.Reference(th.SrcRegistry, th.SrcImage, m.tag),
+		Status: status,
+	}
+	if len(m.destTag) > 0 {
+		t.Destination = report.Reference(th.DestRegistry, th.DestImage, m.destTag)
+	}
+	if m.err == nil {
+		t.SourceDigest = digest.FromBytes(m.manifest.Canonical)
+	}
+	return t
+}
+
+//failed reports tag which was not pushed
+func (th *TagPush) failed(m manifestGetResult, err error) {
+	t := th.tagResult(m, report.TagFailed)
+	t.Fail(err)
+	th.result.Add(t)
+	th.tagFailed(t, m)
+}
+
+//tagFailed sends tag-failed event of failed tag report
+func (th *TagPush) tagFailed(t *report.Tag, m manifestGetResult) {
+	th.Progress.Event(progress.Event{Type: progress.EventTagFailed, Registry: th.SrcRegistry, Repository: th.SrcImage, Tag: m.tag, Digest: t.SourceDigest.String(), Error: t.Error})
+}
+
+//layerReports describes how every layer got to Destination Registry
+func layerReports(checks []layerCheck, uploads []uploadResult) map[digest.Digest]*report.Layer {
+	layers := make(map[digest.Digest]*report.Layer)
+	for _, c := range checks {
+		l := &report.Layer{Digest: c.layer.BlobSum, Status: report.LayerSkipped}
+		switch {
+		case c.err != nil:
+			l.Status = report.LayerFailed
+			l.Error = c.err.Error()
+		case c.mounted:
+			l.Status = report.LayerMounted
+		case !c.remoteExist:
+			l.Size = c.size
 		}
+		layers[c.layer.BlobSum] = l
 	}
-	fmt.Println("All done!")
-	if errorsFound {
-		os.Exit(1)
+	for _, u := range uploads {
+		l := layers[u.layer.BlobSum]
+		l.Status = report.LayerUploaded
+		if u.err != nil {
+			l.Status = report.LayerFailed
+			l.Error = u.err.Error()
+		}
 	}
-	os.Exit(0)
+	return layers
 }
+
+func containsLayer(layers []*report.Layer, l *report.Layer) bool {
+	for _, e := range layers {
+		if e == l {
+			return true
+		}
+	}
+	return false
+}
+
 func appendIfMissing(slice []manifestV1.FSLayer, i manifestV1.FSLayer) []manifestV1.FSLayer {
 	for _, ele := range slice {
 		if ele == i {
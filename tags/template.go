@@ -0,0 +1,114 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+)
+
+//tagNameRegexp is the tag format accepted by Registry
+var tagNameRegexp = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+//tagTemplate renders destination tag names from source tags
+type tagTemplate struct {
+	tmpl        *template.Template
+	re          *regexp.Regexp
+	usesCreated bool
+}
+
+//tagTemplateData is passed to destination tag template.
+//Groups holds capture groups of tag regexp, Groups 0 is the whole match. Named holds named capture groups
+type tagTemplateData struct {
+	Tag     string
+	Groups  []string
+	Named   map[string]string
+	Created time.Time
+}
+
+//newTagTemplate parses destination tag template. Returns nil template when source tags are kept
+func (th *TagPush) newTagTemplate() (*tagTemplate, error) {
+	if len(th.DestTagTemplate) == 0 {
+		return nil, nil
+	}
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(th.DestTagTemplate)
+	if err != nil {
+		return nil, err
+	}
+	t := &tagTemplate{tmpl: tmpl, usesCreated: strings.Contains(th.DestTagTemplate, ".Created")}
+	if len(th.TagRegexp) > 0 {
+		if t.re, err = regexp.Compile(th.TagRegexp); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+//needsManifest reports whether destination tag can be rendered only after source manifest is downloaded
+func (t *tagTemplate) needsManifest() bool {
+	return t != nil && t.usesCreated
+}
+
+//destTag renders destination tag of source tag. Manifest is only required when template uses image creation time
+func (t *tagTemplate) destTag(tag string, m *manifestV1.SignedManifest) (string, error) {
+	if t == nil {
+		return tag, nil
+	}
+	data := tagTemplateData{Tag: tag, Named: make(map[string]string)}
+	if t.re != nil {
+		data.Groups = t.re.FindStringSubmatch(tag)
+		for i, name := range t.re.SubexpNames() {
+			if len(name) > 0 && i < len(data.Groups) {
+				data.Named[name] = data.Groups[i]
+			}
+		}
+	}
+	if t.usesCreated && m != nil && len(m.History) > 0 {
+		var img v1Image
+		if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &img); err != nil {
+			return "", fmt.Errorf("cannot parse image configuration: %v", err)
+		}
+		data.Created = img.Created
+	}
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	destTag := buf.String()
+	if !tagNameRegexp.MatchString(destTag) {
+		return "", fmt.Errorf("template rendered invalid tag %q", destTag)
+	}
+	return destTag, nil
+}
+
+//renderDestTags sets destination tag of every downloaded manifest. Tags failing to render or colliding with other tags are refused
+func (th *TagPush) renderDestTags(t *tagTemplate, manifests []manifestGetResult) ([]manifestGetResult, []overwriteCheck) {
+	rendered := make([]manifestGetResult, 0, len(manifests))
+	refused := make([]overwriteCheck, 0)
+	sources := make(map[string]string)
+	for _, m := range manifests {
+		if m.err != nil {
+			rendered = append(rendered, m)
+			continue
+		}
+		destTag, err := t.destTag(m.tag, &m.manifest)
+		if err == nil {
+			if src, ok := sources[destTag]; ok {
+				err = fmt.Errorf("tags %s and %s are both promoted as %s", src, m.tag, destTag)
+			}
+		}
+		m.destTag = destTag
+		if err != nil {
+			refused = append(refused, overwriteCheck{manifest: m, err: err})
+			continue
+		}
+		sources[destTag] = m.tag
+		rendered = append(rendered, m)
+	}
+	return rendered, refused
+}
@@ -0,0 +1,279 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/notifications"
+	"github.com/vbaksa/promoter/backend"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/logging"
+	"github.com/vbaksa/promoter/rules"
+)
+
+const (
+	//EventsPath is the URL path Registry notification endpoint has to point to
+	EventsPath = "/events"
+
+	queueSize    = 1024
+	retryBackoff = 10 * time.Second
+	maxBackoff   = 5 * time.Minute
+	//maxEnvelopeSize limits notification body, Registry sends envelopes of a few events
+	maxEnvelopeSize = 1 << 20
+	//shutdownTimeout is time given to requests in flight when receiver stops
+	shutdownTimeout = 30 * time.Second
+)
+
+//Server holds webhook receiver structure
+type Server struct {
+	RulesFile string
+	Listen    string
+	Token     string
+	Workers   int
+	Retries   int
+
+	rules *rules.Rules
+	queue *queue
+}
+
+//job is a single image promotion triggered by push event
+type job struct {
+	rule        *rules.Rule
+	destination rules.Destination
+	repository  string
+	tag         string
+	attempt     int
+}
+
+func (j *job) destRepository() string {
+	return j.destination.DestRepository(j.repository)
+}
+
+//key identifies promotion target. Jobs with the same key waiting in the queue are de-duplicated
+func (j *job) key() string {
+	return j.destination.Registry + "/" + j.destRepository() + ":" + j.tag
+}
+
+//log returns entry describing source image and destination of the job
+func (j *job) log() *logrus.Entry {
+	return logging.Image(j.rule.Source.Registry, j.repository).WithFields(logrus.Fields{
+		logging.FieldTag: j.tag,
+		"destination":    j.key(),
+		"attempt":        j.attempt + 1,
+	})
+}
+
+type queue struct {
+	sync.Mutex
+	pending map[string]bool
+	jobs    chan *job
+	closed  bool
+}
+
+//add queues promotion unless the same promotion is already waiting. Returns false when queue is full or closed
+func (q *queue) add(j *job) bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return false
+	}
+	if q.pending[j.key()] {
+		j.log().Info("Skipping duplicate promotion")
+		return true
+	}
+	select {
+	case q.jobs <- j:
+		q.pending[j.key()] = true
+		return true
+	default:
+		return false
+	}
+}
+
+//take marks job as started, so pushes arriving afterwards are promoted again
+func (q *queue) take(j *job) {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.pending, j.key())
+}
+
+//close stops accepting promotions, workers exit once the queue is drained
+func (q *queue) close() {
+	q.Lock()
+	defer q.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+}
+
+//ServeWebhooks listens for Registry push events and promotes images matching the rules until ctx is cancelled.
+//On cancellation requests in flight and running promotions are finished, queued promotions are dropped
+func (s *Server) ServeWebhooks(ctx context.Context) error {
+	r, err := rules.Load(s.RulesFile)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %v", err)
+	}
+	s.rules = r
+	s.queue = &queue{pending: make(map[string]bool), jobs: make(chan *job, queueSize)}
+	if s.Workers < 1 {
+		s.Workers = 1
+	}
+	var workers sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.work(ctx)
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(EventsPath, s.handleEvents)
+	srv := &http.Server{Addr: s.Listen, Handler: mux}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	logrus.WithFields(logrus.Fields{"rules": len(r.Rules), "listen": s.Listen, "path": EventsPath}).Info("Listening for Registry events")
+
+	select {
+	case err = <-listenErr:
+		err = fmt.Errorf("webhook receiver stopped: %v", err)
+	case <-ctx.Done():
+		logrus.Info("Shutting down webhook receiver")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	s.queue.close()
+	workers.Wait()
+	return err
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if len(s.Token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEnvelopeSize)
+	var envelope notifications.Envelope
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, "invalid event envelope: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, event := range envelope.Events {
+		//Only manifest pushes carry tag, blob pushes are ignored
+		if event.Action != notifications.EventActionPush || len(event.Target.Tag) == 0 {
+			continue
+		}
+		for _, rule := range s.rules.Rules {
+			if !fromSource(rule, event) || !rule.Matches(event.Target.Repository, event.Target.Tag) {
+				continue
+			}
+			for _, d := range rule.Destinations {
+				j := &job{rule: rule, destination: d, repository: event.Target.Repository, tag: event.Target.Tag}
+				if !s.queue.add(j) {
+					//Registry keeps retrying the notification until it is accepted
+					http.Error(w, "promotion queue is full", http.StatusServiceUnavailable)
+					return
+				}
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//fromSource reports whether event was sent by rule Source Registry. Host of event target URL or host the push was sent to has to match
+func fromSource(rule *rules.Rule, event notifications.Event) bool {
+	source := registryHost(rule.Source.Registry)
+	if len(source) == 0 {
+		return false
+	}
+	return registryHost(event.Target.URL) == source || registryHost("https://"+event.Request.Host) == source
+}
+
+//registryHost returns lower case host of registry URL with default port removed
+func registryHost(registryURL string) string {
+	u, err := url.Parse(registryURL)
+	if err != nil || len(u.Host) == 0 {
+		return ""
+	}
+	host := strings.ToLower(u.Host)
+	if (u.Scheme == "https" && strings.HasSuffix(host, ":443")) || (u.Scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return host
+}
+
+func (s *Server) work(ctx context.Context) {
+	for j := range s.queue.jobs {
+		s.queue.take(j)
+		if ctx.Err() != nil {
+			j.log().Warn("Dropping promotion, webhook receiver is shutting down")
+			continue
+		}
+		j.log().Info("Promoting")
+		err := s.promote(j)
+		if err == nil {
+			j.log().Info("Promoted")
+			continue
+		}
+		j.log().WithField("error", err.Error()).Warn("Failed to promote")
+		if j.attempt >= s.Retries {
+			j.log().Error("Giving up promotion")
+			continue
+		}
+		s.retry(j)
+	}
+}
+
+//retry queues failed job again after exponential backoff
+func (s *Server) retry(j *job) {
+	backoff := retryBackoff << uint(j.attempt)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	retry := *j
+	retry.attempt++
+	time.AfterFunc(backoff, func() {
+		if !s.queue.add(&retry) {
+			retry.log().Error("Promotion queue is full or closed, dropping retry")
+		}
+	})
+}
+
+func (s *Server) promote(j *job) error {
+	src := j.rule.Source
+	srcHub, err := connection.Connect(src.Registry, src.Username, src.Password, src.Insecure)
+	if err != nil {
+		return fmt.Errorf("cannot connect to Source Registry: %v", err)
+	}
+	dest := j.destination
+	destHub, err := connection.Connect(dest.Registry, dest.Username, dest.Password, dest.Insecure)
+	if err != nil {
+		return fmt.Errorf("cannot connect to Destination Registry: %v", err)
+	}
+	pr := &image.Promote{
+		SrcRegistry:  src.Registry,
+		SrcImage:     j.repository,
+		SrcImageTag:  j.tag,
+		DestRegistry: dest.Registry,
+		DestImage:    j.destRepository(),
+		DestImageTag: j.tag,
+	}
+	return pr.Push(context.Background(), backend.NewRegistry(srcHub), backend.NewRegistry(destHub))
+}